@@ -0,0 +1,293 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// ControlPlaneOptions configures how the runtime authenticates to the control plane and verifies its identity.
+type ControlPlaneOptions struct {
+	// Token is sent as a bearer token with every request. TokenFile is read instead when set, e.g. from a mounted secret.
+	Token     string `yaml:"token,omitempty" json:"-"`
+	TokenFile string `yaml:"tokenFile,omitempty" json:"tokenFile,omitempty"`
+
+	// OAuth2 obtains bearer tokens with the client credentials grant instead of using a static token.
+	OAuth2 *OAuth2Options `yaml:"oauth2,omitempty" json:"oauth2,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities trusted for the control plane, in addition to the system ones.
+	CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+
+	// CertFile and KeyFile are the PEM client certificate and key presented for mutual TLS.
+	CertFile string `yaml:"certFile,omitempty" json:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty" json:"keyFile,omitempty"`
+
+	// Timeout bounds a single request. Zero uses the default of 30 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// Retries is how many times a failed request is retried. Zero uses the default of 3, a negative value disables retries.
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+}
+
+type OAuth2Options struct {
+	TokenURL string `yaml:"tokenURL" json:"tokenURL"`
+	ClientID string `yaml:"clientId" json:"clientId"`
+	// ClientSecret authenticates the client. ClientSecretFile is read instead when set.
+	ClientSecret     string   `yaml:"clientSecret,omitempty" json:"-"`
+	ClientSecretFile string   `yaml:"clientSecretFile,omitempty" json:"clientSecretFile,omitempty"`
+	Scopes           []string `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+}
+
+const defaultControlPlaneTimeout = 30 * time.Second
+
+var (
+	// ErrUnauthorized is matched by a StatusError for 401 and 403 responses.
+	ErrUnauthorized = errors.New("not authorized by the control plane")
+	// ErrNotFound is matched by a StatusError for 404 responses.
+	ErrNotFound = errors.New("not found on the control plane")
+	// ErrInvalidResponse is returned when a successful response cannot be decoded.
+	ErrInvalidResponse = errors.New("invalid response from the control plane")
+)
+
+// StatusError is returned when the control plane responds with an error status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("control plane responded with %s: %s", e.Status, e.Body)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	default:
+		return false
+	}
+}
+
+// maxErrorBody is how much of an error response is kept in a StatusError.
+const maxErrorBody = 512
+
+func statusError(statusCode int, status string, body []byte) *StatusError {
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxErrorBody {
+		text = text[:maxErrorBody] + "..."
+	}
+	return &StatusError{StatusCode: statusCode, Status: status, Body: text}
+}
+
+// decodeResponse decodes the JSON body of a successful response into v.
+func decodeResponse(resp *resty.Response, v any) error {
+	if resp.IsError() {
+		return statusError(resp.StatusCode(), resp.Status(), resp.Body())
+	}
+	err := json.Unmarshal(resp.Body(), v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	return nil
+}
+
+// newClient returns the control plane client. Failed requests are retried with exponential backoff.
+func newClient(opts ControlPlaneOptions) (*resty.Client, error) {
+	tlsConfig, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultControlPlaneTimeout
+	}
+
+	retries := controlPlaneRetries
+	if opts.Retries < 0 {
+		retries = 0
+	} else if opts.Retries > 0 {
+		retries = opts.Retries
+	}
+
+	client := resty.New().
+		SetTransport(transport).
+		SetTimeout(timeout).
+		SetRetryCount(retries).
+		SetRetryWaitTime(5 * time.Second).
+		SetRetryMaxWaitTime(20 * time.Second).
+		AddRetryCondition(func(resp *resty.Response, err error) bool {
+			return err != nil || resp.StatusCode() == http.StatusTooManyRequests || resp.StatusCode() >= http.StatusInternalServerError
+		})
+
+	switch {
+	case opts.OAuth2 != nil:
+		source, err := newTokenSource(*opts.OAuth2, &http.Client{Transport: transport, Timeout: timeout})
+		if err != nil {
+			return nil, err
+		}
+		client.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+			token, err := source.token(r.Context())
+			if err != nil {
+				return err
+			}
+			r.SetAuthToken(token)
+			return nil
+		})
+		client.OnAfterResponse(func(c *resty.Client, r *resty.Response) error {
+			// The token may have been revoked, so a new one is requested next time.
+			if r.StatusCode() == http.StatusUnauthorized {
+				source.invalidate()
+			}
+			return nil
+		})
+	case opts.Token != "" || opts.TokenFile != "":
+		token, err := secretValue(opts.Token, opts.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read control plane token: %w", err)
+		}
+		client.SetAuthToken(token)
+	}
+
+	return client, nil
+}
+
+func (opts ControlPlaneOptions) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read control plane CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in control plane CA bundle %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load control plane client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// secretValue returns the content of file when set, or else value.
+func secretValue(value string, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// expiryDelta renews tokens this long before they expire, so they do not expire in flight.
+const expiryDelta = 30 * time.Second
+
+// tokenSource obtains and caches access tokens with the OAuth2 client credentials grant.
+type tokenSource struct {
+	opts   OAuth2Options
+	secret string
+	client *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func newTokenSource(opts OAuth2Options, client *http.Client) (*tokenSource, error) {
+	if opts.TokenURL == "" || opts.ClientID == "" {
+		return nil, errors.New("oauth2 requires tokenURL and clientId")
+	}
+	secret, err := secretValue(opts.ClientSecret, opts.ClientSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oauth2 client secret: %w", err)
+	}
+	return &tokenSource{opts: opts, secret: secret, client: client}, nil
+}
+
+func (s *tokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Now().Before(s.expiry) {
+		return s.accessToken, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.opts.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.opts.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.opts.ClientID), url.QueryEscape(s.secret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		data := make([]byte, maxErrorBody)
+		n, _ := resp.Body.Read(data)
+		return "", fmt.Errorf("failed to request oauth2 token: %w", statusError(resp.StatusCode, resp.Status, data[:n]))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil || body.AccessToken == "" {
+		return "", fmt.Errorf("failed to request oauth2 token: %w", ErrInvalidResponse)
+	}
+
+	s.accessToken = body.AccessToken
+	s.expiry = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - expiryDelta)
+	if body.ExpiresIn <= 0 {
+		// Without an expiry the token is used until the control plane rejects it.
+		s.expiry = time.Now().Add(time.Hour)
+	}
+	return s.accessToken, nil
+}
+
+func (s *tokenSource) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessToken = ""
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed client certificate and its key, and returns their paths.
+func writeCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "runtime"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile, cert
+}
+
+func TestClientBearerToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"ok": true}`)
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	client, err := newClient(ControlPlaneOptions{TokenFile: tokenFile})
+	require.NoError(t, err)
+	resp, err := client.R().Get(server.URL)
+	require.NoError(t, err)
+	var body map[string]bool
+	assert.NoError(t, decodeResponse(resp, &body))
+
+	client, err = newClient(ControlPlaneOptions{Token: "wrong"})
+	require.NoError(t, err)
+	resp, err = client.R().Get(server.URL)
+	require.NoError(t, err)
+	err = decodeResponse(resp, &body)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+}
+
+func TestClientOAuth2(t *testing.T) {
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			id, secret, _ := r.BasicAuth()
+			require.NoError(t, r.ParseForm())
+			if id != "runtime" || secret != "s3cret" || r.PostForm.Get("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, "jobs:read", r.PostForm.Get("scope"))
+			_, _ = fmt.Fprint(w, `{"access_token": "issued", "token_type": "Bearer", "expires_in": 3600}`)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer issued" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = fmt.Fprint(w, "<html>maintenance</html>")
+	}))
+	defer server.Close()
+
+	client, err := newClient(ControlPlaneOptions{OAuth2: &OAuth2Options{
+		TokenURL:     server.URL + "/token",
+		ClientID:     "runtime",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"jobs:read"},
+	}})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.R().Get(server.URL + "/runtime/jobs")
+		require.NoError(t, err)
+		var body any
+		assert.ErrorIs(t, decodeResponse(resp, &body), ErrInvalidResponse)
+	}
+	assert.Equal(t, 1, tokenRequests, "the token is cached until it expires")
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeCertificate(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[]`)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	client, err := newClient(ControlPlaneOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	client.SetRetryCount(0)
+	resp, err := client.R().Get(server.URL)
+	require.NoError(t, err)
+	var body []any
+	assert.NoError(t, decodeResponse(resp, &body))
+
+	client, err = newClient(ControlPlaneOptions{CAFile: caFile})
+	require.NoError(t, err)
+	client.SetRetryCount(0)
+	_, err = client.R().Get(server.URL)
+	assert.Error(t, err, "the server requires a client certificate")
+
+	client, err = newClient(ControlPlaneOptions{})
+	require.NoError(t, err)
+	client.SetRetryCount(0)
+	_, err = client.R().Get(server.URL)
+	assert.Error(t, err, "the server certificate is not trusted without the CA bundle")
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// EncryptionOptions configures the encryption of the plans stored on disk. Plans are stored in plain text
+// when neither Key nor KeyFile is set.
+type EncryptionOptions struct {
+	// Key is the base64 encoded 32 byte key the plans are encrypted with, e.g. from AR_ENCRYPTION_KEY.
+	Key string `yaml:"key,omitempty" json:"-"`
+	// KeyFile holds the key, either base64 encoded or as 32 raw bytes.
+	KeyFile string `yaml:"keyFile,omitempty" json:"keyFile,omitempty"`
+}
+
+// encryptedHeader starts every encrypted plan file, followed by the base64 encoded envelope.
+const encryptedHeader = "ar-encrypted:v1:xchacha20poly1305:"
+
+// planCipher encrypts plan files with envelope encryption: every file is encrypted with its own random data key,
+// which is stored in the file encrypted with the configured key.
+type planCipher struct {
+	key []byte
+}
+
+func newPlanCipher(opts EncryptionOptions) (*planCipher, error) {
+	var key []byte
+	switch {
+	case opts.Key != "":
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(opts.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %w", err)
+		}
+		key = decoded
+	case opts.KeyFile != "":
+		data, err := os.ReadFile(opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %w", err)
+		}
+		key = data
+		if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err == nil {
+			key = decoded
+		}
+	default:
+		return nil, nil
+	}
+
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", chacha20poly1305.KeySize, len(key))
+	}
+	return &planCipher{key: key}, nil
+}
+
+// seal encrypts the plaintext with the key, prefixing it with the random nonce.
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(encryptedHeader)), nil
+}
+
+// unseal decrypts what seal returned.
+func unseal(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(encryptedHeader))
+}
+
+func (c *planCipher) encrypt(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := seal(c.key, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := append(wrappedKey, ciphertext...)
+	return []byte(encryptedHeader + base64.StdEncoding.EncodeToString(envelope) + "\n"), nil
+}
+
+func (c *planCipher) decrypt(data []byte) ([]byte, error) {
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(string(data), encryptedHeader)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted plan: %w", err)
+	}
+
+	// The wrapped data key is its nonce, the key itself and the authentication tag.
+	wrappedKeySize := chacha20poly1305.NonceSizeX + chacha20poly1305.KeySize + chacha20poly1305.Overhead
+	if len(envelope) < wrappedKeySize {
+		return nil, errors.New("invalid encrypted plan: too short")
+	}
+
+	dataKey, err := unseal(c.key, envelope[:wrappedKeySize])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt plan, is the encryption key the one it was written with? %w", err)
+	}
+	plaintext, err := unseal(dataKey, envelope[wrappedKeySize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt plan: %w", err)
+	}
+	return plaintext, nil
+}
+
+// writePlanFile writes a plan or plan revision readable only by the runtime, encrypted if a key is configured.
+// The file is replaced at once, so readers never see it half written.
+func (cm *ConfigurationManager) writePlanFile(path string, data []byte) error {
+	if cm.cipher != nil {
+		var err error
+		data, err = cm.cipher.encrypt(data)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt plan: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp creates the file with 0600 permissions.
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readPlanFile reads a plan or plan revision, decrypting it if it is encrypted. Plans in plain text are read
+// as they are, so plans written by hand or before a key was configured keep working, and are encrypted in place
+// when a key is configured.
+func (cm *ConfigurationManager) readPlanFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(encryptedHeader)) {
+		if cm.cipher != nil {
+			if err := cm.writePlanFile(path, data); err != nil {
+				log.WithField("file", path).Warnf("Failed to encrypt plan in plain text: %s", err)
+			} else {
+				log.WithField("file", path).Info("Encrypted plan in plain text")
+			}
+		}
+		return data, nil
+	}
+	if cm.cipher == nil {
+		return nil, errors.New("plan is encrypted, but no encryption key is configured")
+	}
+	return cm.cipher.decrypt(data)
+}
+
+// encryptPlanFiles encrypts the plans and plan revisions still stored in plain text, e.g. since before a key
+// was configured.
+func (cm *ConfigurationManager) encryptPlanFiles() error {
+	return filepath.WalkDir(assessmentPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if d.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			return nil
+		}
+		if _, err := cm.readPlanFile(path); err != nil {
+			log.WithField("file", path).Errorf("Skipping unreadable plan: %s", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEncryptedPlans(t *testing.T) {
+	assessmentPath = t.TempDir()
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))+"\n"), 0600))
+	cipher, err := newPlanCipher(EncryptionOptions{KeyFile: keyFile})
+	require.NoError(t, err)
+	cm := &ConfigurationManager{config: Config{PlanHistory: -1}, cipher: cipher}
+
+	plan := model.JobSpec{Id: "plan-1", Title: "Plan", Tasks: []model.Task{{
+		Id:       "task-1",
+		Schedule: "0 * * * * *",
+		Activities: []model.Activity{{
+			Id:       "activity-1",
+			Provider: model.Provider{Name: "busy", Image: "ghcr.io/compliance-framework/busy", Tag: "1.0.0", Configuration: map[string]string{"subscription": "subscription-id"}},
+		}},
+	}}}
+	require.NoError(t, cm.writeJobSpec(plan))
+
+	path := filepath.Join(assessmentPath, "plan-1.yaml")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), encryptedHeader))
+	assert.NotContains(t, string(data), "subscription-id")
+
+	// Plans in plain text are still read, e.g. ones written before a key was configured.
+	plain, err := yaml.Marshal(model.JobSpec{Id: "plan-2", Title: "Plain", Tasks: plan.Tasks})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(assessmentPath, "plan-2.yaml"), plain, 0644))
+
+	require.NoError(t, cm.loadJobSpecs(assessmentPath))
+	require.Len(t, cm.JobSpecs(), 2)
+	assert.Equal(t, plan, cm.JobSpecs()[0])
+
+	// Plans and revisions in plain text are encrypted once a key is configured.
+	revision := filepath.Join(assessmentPath, historyDirName, "plan-2", "1-"+strings.Repeat("0", 12)+".yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(revision), 0700))
+	require.NoError(t, os.WriteFile(revision, plain, 0644))
+	require.NoError(t, cm.encryptPlanFiles())
+	for _, file := range []string{filepath.Join(assessmentPath, "plan-2.yaml"), revision} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), encryptedHeader), file)
+		data, err = cm.readPlanFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, plain, data)
+	}
+
+	other, err := newPlanCipher(EncryptionOptions{Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))})
+	require.NoError(t, err)
+	for _, c := range []*planCipher{nil, other} {
+		_, err = (&ConfigurationManager{cipher: c}).readPlanFile(path)
+		assert.Error(t, err, "encrypted plans can only be read with their key")
+	}
+
+	_, err = newPlanCipher(EncryptionOptions{Key: base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.Error(t, err)
+}
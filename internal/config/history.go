@@ -0,0 +1,230 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// historyDirName holds the previous revisions of every plan, in a directory per plan named after its id.
+// Revisions are stored as <unix nanoseconds>-<revision>.yaml, so they sort by the time they were recorded.
+const historyDirName = ".history"
+
+// rollbackFileName records, in the history directory of a plan, the revision the plan was rolled back from.
+// Updates to that revision are ignored, so a rollback lasts until the control plane sends another one.
+const rollbackFileName = "rollback"
+
+// defaultPlanHistory is how many revisions of every plan are kept when Config.PlanHistory is zero.
+const defaultPlanHistory = 10
+
+// PlanRevision is a recorded revision of a plan.
+type PlanRevision struct {
+	Revision  string    `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	file      string
+}
+
+func historyDir(id string) (string, error) {
+	if _, err := jobSpecPath(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(assessmentPath, historyDirName, id), nil
+}
+
+// History returns the recorded revisions of the plan, newest first.
+func (cm *ConfigurationManager) History(id string) ([]PlanRevision, error) {
+	dir, err := historyDir(id)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []PlanRevision{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan history: %w", err)
+	}
+
+	revisions := make([]PlanRevision, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".yaml")
+		timestamp, revision, ok := strings.Cut(name, "-")
+		if !ok || name == file.Name() {
+			continue
+		}
+		nanos, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, PlanRevision{
+			Revision:  revision,
+			Timestamp: time.Unix(0, nanos).UTC(),
+			file:      filepath.Join(dir, file.Name()),
+		})
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Timestamp.After(revisions[j].Timestamp)
+	})
+	return revisions, nil
+}
+
+// ReadRevision returns the plan as it was at the revision.
+func (cm *ConfigurationManager) ReadRevision(id string, revision string) (model.JobSpec, error) {
+	revisions, err := cm.History(id)
+	if err != nil {
+		return model.JobSpec{}, err
+	}
+
+	for _, r := range revisions {
+		if r.Revision != revision {
+			continue
+		}
+		data, err := cm.readPlanFile(r.file)
+		if err != nil {
+			return model.JobSpec{}, fmt.Errorf("failed to read plan revision: %w", err)
+		}
+		var jobSpec model.JobSpec
+		err = yaml.Unmarshal(data, &jobSpec)
+		if err != nil {
+			return model.JobSpec{}, fmt.Errorf("failed to unmarshal plan revision: %w", err)
+		}
+		return jobSpec, nil
+	}
+	return model.JobSpec{}, fmt.Errorf("revision %s of job %s not found", revision, id)
+}
+
+// recordRevision adds the plan to its history unless it is the latest revision already, and drops the
+// revisions beyond the configured number to keep.
+func (cm *ConfigurationManager) recordRevision(jobSpec model.JobSpec) error {
+	keep := cm.config.PlanHistory
+	if keep < 0 {
+		return nil
+	}
+	if keep == 0 {
+		keep = defaultPlanHistory
+	}
+
+	revisions, err := cm.History(jobSpec.Id)
+	if err != nil {
+		return err
+	}
+
+	revision := jobSpec.Revision()
+	if len(revisions) == 0 || revisions[0].Revision != revision {
+		dir, err := historyDir(jobSpec.Id)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return fmt.Errorf("failed to create plan history directory: %w", err)
+		}
+
+		// The revision does not depend on whether the plan is paused, so neither does what is recorded.
+		jobSpec.Paused = false
+		data, err := yaml.Marshal(jobSpec)
+		if err != nil {
+			return fmt.Errorf("failed to marshal yaml data: %w", err)
+		}
+
+		// Keep the timestamps increasing even if the clock is coarse or goes back.
+		timestamp := time.Now().UnixNano()
+		if len(revisions) > 0 && timestamp <= revisions[0].Timestamp.UnixNano() {
+			timestamp = revisions[0].Timestamp.UnixNano() + 1
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d-%s.yaml", timestamp, revision))
+		err = cm.writePlanFile(file, data)
+		if err != nil {
+			return fmt.Errorf("failed to write plan revision: %w", err)
+		}
+
+		revisions = append([]PlanRevision{{Revision: revision, Timestamp: time.Unix(0, timestamp).UTC(), file: file}}, revisions...)
+		log.WithFields(log.Fields{
+			"id":       jobSpec.Id,
+			"revision": revision,
+		}).Info("Recorded plan revision")
+	}
+
+	for _, r := range revisions[min(keep, len(revisions)):] {
+		err = os.Remove(r.file)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old plan revision: %w", err)
+		}
+	}
+	return nil
+}
+
+// Rollback restores the plan to the revision and has it rescheduled. It returns the status a rollback event is
+// acknowledged with.
+func (cm *ConfigurationManager) Rollback(id string, revision string) (model.PlanStatus, error) {
+	return cm.apply(model.PlanEvent{Type: model.PlanRolledBack, Data: model.JobSpec{Id: id}, Revision: revision})
+}
+
+// rolledBackFrom returns the revision the plan was rolled back from, or "" if it has not been rolled back since
+// it was last updated.
+func rolledBackFrom(id string) string {
+	dir, err := historyDir(id)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(dir, rollbackFileName))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// setRolledBackFrom records the revision the plan was rolled back from. Rolling back again keeps the first one,
+// which is the revision the control plane has.
+func setRolledBackFrom(id string, revision string) error {
+	if revision == "" || rolledBackFrom(id) != "" {
+		return nil
+	}
+	dir, err := historyDir(id)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create plan history directory: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dir, rollbackFileName), []byte(revision+"\n"), 0600)
+}
+
+// clearRollback forgets that the plan was rolled back.
+func clearRollback(id string) error {
+	dir, err := historyDir(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, rollbackFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear plan rollback: %w", err)
+	}
+	return nil
+}
+
+// revisionToRestore returns the plan at the revision, paused or running as it is now.
+func (cm *ConfigurationManager) revisionToRestore(id string, revision string) (model.JobSpec, error) {
+	jobSpec, err := cm.ReadRevision(id, revision)
+	if err != nil {
+		return model.JobSpec{}, err
+	}
+
+	for _, current := range cm.JobSpecs() {
+		if current.Id == id {
+			jobSpec.Paused = current.Paused
+		}
+	}
+	return jobSpec, nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanHistory(t *testing.T) {
+	assessmentPath = t.TempDir()
+	cm := &ConfigurationManager{config: Config{PlanHistory: 2}}
+
+	binary := filepath.Join(t.TempDir(), "plugin")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+	plan := func(title string) model.JobSpec {
+		return model.JobSpec{Id: "plan-1", Title: title, Tasks: []model.Task{{
+			Id:       "task-1",
+			Schedule: "*/10 * * * * *",
+			Activities: []model.Activity{{
+				Id:       "activity-1",
+				Provider: model.Provider{Name: "history-test", Image: "file://" + binary, Tag: "1.0.0", Configuration: map[string]string{}},
+			}},
+		}}}
+	}
+	apply := func(planEvent model.PlanEvent) {
+		_, err := cm.apply(planEvent)
+		require.NoError(t, err)
+	}
+
+	v1, v2, v3 := plan("First"), plan("Second"), plan("Third")
+	apply(model.PlanEvent{Type: model.PlanActivated, Data: v1})
+	apply(model.PlanEvent{Type: model.PlanPaused, Data: model.JobSpec{Id: "plan-1"}})
+	apply(model.PlanEvent{Type: model.PlanUpdated, Data: v2})
+
+	revisions, err := cm.History("plan-1")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2, "pausing a plan does not record a revision")
+	assert.Equal(t, v2.Revision(), revisions[0].Revision)
+	assert.Equal(t, v1.Revision(), revisions[1].Revision)
+
+	status, err := cm.Rollback("plan-1", v1.Revision())
+	require.NoError(t, err)
+	assert.Equal(t, model.PlanScheduled, status)
+	assert.Equal(t, []model.JobSpec{v1}, cm.JobSpecs())
+
+	// The rollback lasts while the control plane still has the revision it replaced.
+	assert.Empty(t, cm.diff([]model.JobSpec{v2}))
+	status, err = cm.apply(model.PlanEvent{Type: model.PlanUpdated, Data: v2})
+	require.NoError(t, err)
+	assert.Equal(t, model.PlanSkipped, status)
+	assert.Equal(t, []model.JobSpec{v1}, cm.JobSpecs())
+
+	apply(model.PlanEvent{Type: model.PlanUpdated, Data: v3})
+	assert.Equal(t, []model.JobSpec{v3}, cm.JobSpecs())
+	assert.Empty(t, rolledBackFrom("plan-1"), "a newer revision ends the rollback")
+	revisions, err = cm.History("plan-1")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2, "only the configured number of revisions is kept")
+	assert.Equal(t, v3.Revision(), revisions[0].Revision)
+	assert.Equal(t, v1.Revision(), revisions[1].Revision)
+
+	_, err = cm.Rollback("plan-1", v2.Revision())
+	assert.Error(t, err, "revisions that were dropped cannot be restored")
+}
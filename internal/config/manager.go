@@ -1,16 +1,22 @@
 package config
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/compliance-framework/assessment-runtime/internal/event"
 	"github.com/compliance-framework/assessment-runtime/internal/model"
 	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
 	"github.com/compliance-framework/assessment-runtime/internal/registry"
+	"github.com/compliance-framework/assessment-runtime/internal/secret"
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -18,21 +24,61 @@ import (
 
 // Config represents the entire configuration loaded from the Yaml file.
 type Config struct {
-	RuntimeId         string `yaml:"runtimeId" json:"runtimeId"`
-	ControlPlaneURL   string `yaml:"controlPlaneURL" json:"controlPlaneURL"`
-	EventBusURL       string `yaml:"eventBusURL" json:"eventBusURL"`
+	RuntimeId       string `yaml:"runtimeId" json:"runtimeId"`
+	ControlPlaneURL string `yaml:"controlPlaneURL" json:"controlPlaneURL"`
+	EventBusURL     string `yaml:"eventBusURL" json:"eventBusURL"`
+	// EventBus configures TLS and authentication for the connection to EventBusURL.
+	EventBus event.Options `yaml:"eventBus" json:"eventBus"`
+	// EventBusServer runs a NATS server in the runtime, which the runtime connects to instead of EventBusURL.
+	EventBusServer event.ServerOptions `yaml:"eventBusServer" json:"eventBusServer"`
+	// ControlPlane configures authentication and TLS for the requests to ControlPlaneURL.
+	ControlPlane ControlPlaneOptions `yaml:"controlPlane" json:"controlPlane"`
+	Registry     registry.Options    `yaml:"registry" json:"registry"`
+	// Secrets limits the environment variables and files the secret references of plans can read.
+	Secrets secret.Options `yaml:"secrets" json:"secrets"`
+	// Labels are advertised to the control plane, which matches them against the runtime selectors of plans,
+	// e.g. region: eu-west-1 or cloud: aws.
+	Labels map[string]string `yaml:"labels" json:"labels"`
+	// HeartbeatInterval is how often the runtime reports that it is alive. Zero uses the default of 30 seconds.
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval" json:"heartbeatInterval"`
+	// ResyncInterval is how often the plans are fully resynchronised with the control plane, which repairs
+	// missed plan events. Zero uses the default of 5 minutes, a negative value disables it.
+	ResyncInterval time.Duration `yaml:"resyncInterval" json:"resyncInterval"`
+	// WatchInterval is how often the assessments directory is checked for changes made by hand or by
+	// file sync tools. Zero uses the default of 2 seconds, a negative value disables it.
+	WatchInterval time.Duration `yaml:"watchInterval" json:"watchInterval"`
+	// PlanHistory is how many revisions of every plan are kept for rollbacks. Zero uses the default of 10,
+	// a negative value disables the history.
+	PlanHistory int `yaml:"planHistory" json:"planHistory"`
+	// Encryption configures the encryption of the plans stored in the assessments directory.
+	Encryption EncryptionOptions `yaml:"encryption" json:"encryption"`
 }
 
+const (
+	defaultResyncInterval = 5 * time.Minute
+	defaultWatchInterval  = 2 * time.Second
+	controlPlaneRetries   = 3
+)
+
 type ConfigurationManager struct {
 	config   Config
+	mu       sync.RWMutex
 	jobSpecs []model.JobSpec
 	client   *resty.Client
+	// etag of the last plans received from the control plane, sent back as If-None-Match
+	etag string
+	// events serialises plan events from the event bus, so they are applied one at a time
+	events chan event.Request[model.PlanEvent]
+	// applyMu serialises changes to the assessments directory with reloading it
+	applyMu sync.Mutex
+	// cipher encrypts the plans stored on disk, nil when they are stored in plain text
+	cipher *planCipher
+	// plugins caches the labels of the installed plugins, nil until they are listed
+	plugins map[string]string
 }
 
-var (
-	configPath     string
-	assessmentPath string
-)
+// assessmentPath is the directory the plans are stored in.
+var assessmentPath string
 
 func getExecutableDir() (string, error) {
 	execPath, err := os.Executable()
@@ -42,49 +88,218 @@ func getExecutableDir() (string, error) {
 	return filepath.Dir(execPath), nil
 }
 
+// NewConfigurationManager creates a configuration manager from the configuration file and the environment.
 func NewConfigurationManager() (*ConfigurationManager, error) {
-	execDir, err := getExecutableDir()
+	settings, err := LoadSettings(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return NewConfigurationManagerFromSettings(settings)
+}
+
+// NewConfigurationManagerFromSettings creates a configuration manager for the effective configuration.
+func NewConfigurationManagerFromSettings(settings Settings) (*ConfigurationManager, error) {
+	cm, err := newConfigurationManager(settings)
 	if err != nil {
 		return nil, err
 	}
 
-	configPath = filepath.Join(execDir, "config.yml")
-	assessmentPath = filepath.Join(execDir, "assessments")
+	cm.watchPlugins()
 
-	cm := &ConfigurationManager{
-		client: resty.New().SetRetryCount(0).SetRetryWaitTime(5 * time.Second).SetRetryMaxWaitTime(20 * time.Second),
+	cm.client, err = newClient(cm.config.ControlPlane)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control plane client: %w", err)
 	}
 
-	err = cm.loadConfig(configPath)
+	// The local plans are loaded first, so what was changed on this runtime is kept.
+	err = cm.loadJobSpecs(assessmentPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load configuration: %w", err)
+		return nil, err
 	}
 
-	jobs, err := cm.getJobSpecs()
+	jobs, etag, _, err := cm.getJobSpecs()
 	if err != nil {
 		log.Warn("failed to get job configurations from control plane. loading jobs from local config")
-		err = cm.loadJobSpecs(assessmentPath)
+	} else {
+		err = cm.writeJobSpecs(cm.withLocalState(jobs))
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		cm.jobSpecs = jobs
-
-		err = cm.writeJobSpecs(jobs)
+		// The control plane is authoritative, so plans it no longer has are not kept around.
+		err = cm.removeStaleJobSpecs(jobs)
 		if err != nil {
 			return nil, err
 		}
+		cm.setETag(etag)
+	}
+
+	// The plans from the control plane are loaded back from disk too, so they are validated and their revisions
+	// recorded before they are scheduled, like any other plan.
+	err = cm.loadJobSpecs(assessmentPath)
+	if err != nil {
+		return nil, err
 	}
 	return cm, nil
 }
 
-func (cm *ConfigurationManager) Listen() {
-	topic := "runtime.configuration" //fmt.Sprintf(, cm.config.RuntimeId)
+// NewLocalConfigurationManager creates a configuration manager that only works on the plans stored locally,
+// without contacting the control plane. It is used by the commands that inspect or change the local plans.
+func NewLocalConfigurationManager(settings Settings) (*ConfigurationManager, error) {
+	cm, err := newConfigurationManager(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cm.loadJobSpecs(assessmentPath)
+	if err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+func newConfigurationManager(settings Settings) (*ConfigurationManager, error) {
+	assessmentPath = settings.AssessmentsDir()
+
+	// Plans hold provider configurations, so they are only readable by the runtime.
+	err := os.MkdirAll(assessmentPath, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create assessments directory: %w", err)
+	}
+	err = os.Chmod(assessmentPath, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restrict assessments directory: %w", err)
+	}
+
+	cm := &ConfigurationManager{
+		config: settings.Config,
+		events: make(chan event.Request[model.PlanEvent]),
+	}
+
+	cm.cipher, err = newPlanCipher(cm.config.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	if cm.cipher != nil {
+		err = cm.encryptPlanFiles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt plans: %w", err)
+		}
+	}
+	return cm, nil
+}
+
+// Topic returns the subject of the event bus scoped to this runtime, e.g. runtime.<runtimeId>.configuration.
+func (cm *ConfigurationManager) Topic(name string) string {
+	return fmt.Sprintf("runtime.%s.%s", cm.config.RuntimeId, name)
+}
+
+// Labels returns the labels advertised by the runtime: the configured ones, the platform it runs on
+// and the plugins it has installed, e.g. plugin.azure-cli: 1.0.0.
+func (cm *ConfigurationManager) Labels() map[string]string {
+	labels := map[string]string{
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+	}
+
+	for key, value := range cm.pluginLabels() {
+		labels[key] = value
+	}
+
+	// Configured labels take precedence over the detected ones.
+	for key, value := range cm.config.Labels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// pluginLabels returns the labels of the installed plugins. Listing the plugins reads the plugins directory,
+// so the labels are cached, and refreshed by watchPlugins whenever plugins are installed or removed.
+func (cm *ConfigurationManager) pluginLabels() map[string]string {
+	cm.mu.RLock()
+	labels := cm.plugins
+	cm.mu.RUnlock()
+	if labels != nil {
+		return labels
+	}
+	return cm.refreshPluginLabels()
+}
 
-	// Subscribe to job configuration updates
-	ch, err := event.Subscribe[model.PlanEvent](topic)
+func (cm *ConfigurationManager) refreshPluginLabels() map[string]string {
+	plugins, err := registry.Inventory()
 	if err != nil {
-		log.Errorf("failed to subscribe to job configuration updates: %s", err)
+		// Not cached, so listing them is retried.
+		log.Warnf("failed to list installed plugins: %s", err)
+		return map[string]string{}
+	}
+
+	labels := make(map[string]string, len(plugins))
+	for _, plugin := range plugins {
+		labels["plugin."+plugin.Name] = plugin.Tag
+	}
+
+	cm.mu.Lock()
+	cm.plugins = labels
+	cm.mu.Unlock()
+	return labels
+}
+
+// watchPlugins refreshes the plugin labels every time an install or a garbage collection has finished,
+// until the returned function is called.
+func (cm *ConfigurationManager) watchPlugins() func() {
+	ch, err := pubsub.Subscribe(pubsub.PluginsChanged)
+	if err != nil {
+		log.Errorf("failed to subscribe to plugin changes: %s", err)
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-ch:
+				if !ok {
+					return
+				}
+				cm.refreshPluginLabels()
+			}
+		}
+	}()
+	return func() {
+		pubsub.Unsubscribe(pubsub.PluginsChanged, ch)
+		close(done)
+	}
+}
+
+// targets reports whether the plan is meant to run on this runtime.
+func (cm *ConfigurationManager) targets(jobSpec model.JobSpec) bool {
+	if jobSpec.RuntimeSelector.Matches(cm.Labels()) {
+		return true
+	}
+	log.WithFields(log.Fields{
+		"job":      jobSpec.Id,
+		"selector": jobSpec.RuntimeSelector,
+	}).Info("Skipping plan targeted at other runtimes")
+	return false
+}
+
+func (cm *ConfigurationManager) Listen() {
+	// Plans are sent to a single runtime on its own topic, or to the whole fleet on the shared topic
+	// and routed by their runtime selector.
+	topics := []string{cm.Topic("configuration"), "runtime.configuration"}
+
+	for _, topic := range topics {
+		sub, err := event.SubscribeRequests[model.PlanEvent](topic)
+		if err != nil {
+			log.Errorf("failed to subscribe to job configuration updates on %s: %s", topic, err)
+			continue
+		}
+		go func() {
+			for req := range sub {
+				cm.events <- req
+			}
+		}()
 	}
 
 	// Listen for job configuration updates
@@ -92,66 +307,338 @@ func (cm *ConfigurationManager) Listen() {
 	go func() {
 		for {
 			select {
-			case planEvent := <-ch:
-				log.Infof("received job configuration change: %s", planEvent.Type)
-				if planEvent.Type == "activated" || planEvent.Type == "updated" {
-					err := cm.writeJobSpec(planEvent.Data)
-					if err != nil {
-						log.Errorf("failed to write job config: %s for job: %s", err, planEvent.Data.Id)
-						return
-					}
-					err = cm.loadJobSpecs(assessmentPath)
-					if err != nil {
-						log.Error("failed to load job specs")
-					}
-					pubsub.Publish(pubsub.Event{
-						Type: pubsub.ConfigurationUpdated,
-						Data: cm.jobSpecs,
-					})
-					err = registry.DownloadPackages(cm.Packages())
-					if err != nil {
-					    log.Errorf("Error downloading some of the plugins: %s", err)
-					}
-				} else if planEvent.Type == "delete" {
-					err := os.Remove(filepath.Join("assessments", planEvent.Data.Id+".yaml"))
-					if err != nil {
-						log.Errorf("failed to delete job config: %s for job: %s", err, planEvent.Data.Id)
-					}
+			case req := <-cm.events:
+				cm.handle(req)
+			}
+		}
+	}()
+}
+
+// Resync periodically fetches all plans from the control plane until ctx is done, and applies the differences
+// with the local plans as plan events, the same way as events received on the event bus.
+func (cm *ConfigurationManager) Resync(ctx context.Context) {
+	interval := cm.config.ResyncInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultResyncInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			jobs, etag, modified, err := cm.getJobSpecs()
+			if err != nil {
+				log.Warnf("failed to resync job configurations with the control plane: %s", err)
+				continue
+			}
+			if !modified {
+				log.Debug("job configurations are in sync with the control plane")
+				continue
+			}
+
+			// The ETag is only kept once every change has been applied, otherwise a plan that failed to apply
+			// would never be fetched again.
+			applied := true
+			for _, planEvent := range cm.diff(jobs) {
+				if ctx.Err() != nil {
+					cm.setETag("")
+					return
+				}
+				if err := cm.handle(event.Request[model.PlanEvent]{Data: planEvent}); err != nil {
+					applied = false
 				}
 			}
+			if !applied {
+				cm.setETag("")
+				continue
+			}
+			cm.setETag(etag)
+		}
+	}
+}
+
+// withLocalState returns the plans from the control plane with the pause state of the local plans kept, as plans
+// are paused and resumed with plan events, which the control plane's copy does not reflect. Plans rolled back on
+// this runtime are kept as they are while the control plane still has the revision they were rolled back from.
+func (cm *ConfigurationManager) withLocalState(jobs []model.JobSpec) []model.JobSpec {
+	local := make(map[string]model.JobSpec)
+	for _, jobSpec := range cm.JobSpecs() {
+		local[jobSpec.Id] = jobSpec
+	}
+
+	result := make([]model.JobSpec, 0, len(jobs))
+	for _, jobSpec := range jobs {
+		if current, ok := local[jobSpec.Id]; ok {
+			if rolledBackFrom(jobSpec.Id) == jobSpec.Revision() {
+				// Still the revision the plan was rolled back from, so the rollback stays.
+				jobSpec = current
+			}
+			jobSpec.Paused = current.Paused
+		}
+		result = append(result, jobSpec)
+	}
+	return result
+}
+
+// diff returns the plan events that turn the local plans into the given ones.
+func (cm *ConfigurationManager) diff(jobs []model.JobSpec) []model.PlanEvent {
+	jobs = cm.withLocalState(jobs)
+
+	local := make(map[string][]byte)
+	for _, jobSpec := range cm.JobSpecs() {
+		// Compared as YAML, as that is how they are stored and loaded.
+		data, _ := yaml.Marshal(jobSpec)
+		local[jobSpec.Id] = data
+	}
+
+	events := make([]model.PlanEvent, 0)
+	for _, jobSpec := range jobs {
+		data, _ := yaml.Marshal(jobSpec)
+		existing, ok := local[jobSpec.Id]
+		delete(local, jobSpec.Id)
+
+		if !ok {
+			events = append(events, model.PlanEvent{Type: model.PlanActivated, Data: jobSpec})
+		} else if string(existing) != string(data) {
+			events = append(events, model.PlanEvent{Type: model.PlanUpdated, Data: jobSpec})
+		}
+	}
+	for id := range local {
+		events = append(events, model.PlanEvent{Type: model.PlanDeleted, Data: model.JobSpec{Id: id}})
+	}
+
+	return events
+}
+
+// handle applies a plan event and reports the outcome on runtime.<runtimeId>.configuration.ack, as well as to
+// the reply subject when the control plane sent the event as a request. It returns the error the event failed
+// with, if any; a failing event never stops the listener.
+func (cm *ConfigurationManager) handle(req event.Request[model.PlanEvent]) (err error) {
+	planEvent := req.Data
+	if req.Err != nil {
+		// Nothing can be applied, but the sender is still told the event was rejected.
+		cm.ack(req.Reply, planEvent, model.PlanInvalid, req.Err)
+		return req.Err
+	}
+	log.Infof("received job configuration change: %s", planEvent.Type)
+	cm.ack("", planEvent, model.PlanAccepted, nil)
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("panic while applying %s event for job: %s: %v", planEvent.Type, planEvent.Data.Id, r)
+			err = fmt.Errorf("internal error: %v", r)
+			cm.ack(req.Reply, planEvent, model.PlanFailed, err)
 		}
 	}()
+
+	status, err := cm.apply(planEvent)
+	if err != nil {
+		log.Errorf("failed to apply %s event for job: %s: %s", planEvent.Type, planEvent.Data.Id, err)
+	}
+	cm.ack(req.Reply, planEvent, status, err)
+	return err
 }
 
-func (cm *ConfigurationManager) getJobSpecs() ([]model.JobSpec, error) {
-	resp, err := cm.client.R().Get(cm.config.ControlPlaneURL + "/runtime/jobs")
+func (cm *ConfigurationManager) ack(reply string, planEvent model.PlanEvent, status model.PlanStatus, err error) {
+	ack := model.PlanAck{
+		RuntimeId: cm.config.RuntimeId,
+		PlanId:    planEvent.Data.Id,
+		Event:     planEvent.Type,
+		Status:    status,
+		Timestamp: time.Now().UTC(),
+	}
 	if err != nil {
-		return nil, err
+		ack.Error = err.Error()
+	}
+
+	subjects := []string{cm.Topic("configuration.ack")}
+	if reply != "" {
+		subjects = append(subjects, reply)
+	}
+	for _, subject := range subjects {
+		if err := event.Publish(ack, subject); err != nil {
+			log.WithField("topic", subject).Warnf("failed to acknowledge %s event for job: %s: %s", planEvent.Type, planEvent.Data.Id, err)
+		}
+	}
+}
+
+// apply updates the plans on disk for the plan event, then reloads them and has them rescheduled.
+// It returns the status to acknowledge the event with.
+func (cm *ConfigurationManager) apply(planEvent model.PlanEvent) (model.PlanStatus, error) {
+	cm.applyMu.Lock()
+	defer cm.applyMu.Unlock()
+
+	status := model.PlanScheduled
+	var downloadErr error
+
+	// replaced is the revision of the plan a rollback replaces.
+	var replaced string
+
+	switch planEvent.Type {
+	case model.PlanRolledBack:
+		jobSpec, err := cm.revisionToRestore(planEvent.Data.Id, planEvent.Revision)
+		if err != nil {
+			return model.PlanInvalid, err
+		}
+		for _, current := range cm.JobSpecs() {
+			if current.Id == jobSpec.Id {
+				replaced = current.Revision()
+			}
+		}
+		planEvent.Data = jobSpec
+		fallthrough
+	case model.PlanActivated, model.PlanUpdated:
+		err := validate(planEvent.Data)
+		if err != nil {
+			return model.PlanInvalid, err
+		}
+		if planEvent.Type != model.PlanRolledBack {
+			// A rolled back plan only changes again with a revision other than the one it was rolled back from.
+			if from := rolledBackFrom(planEvent.Data.Id); from != "" && from == planEvent.Data.Revision() {
+				return model.PlanSkipped, nil
+			}
+			err = clearRollback(planEvent.Data.Id)
+			if err != nil {
+				return model.PlanFailed, err
+			}
+		}
+		if !cm.targets(planEvent.Data) {
+			// A plan retargeted away from this runtime no longer runs here.
+			removed, err := cm.removeInstalledJobSpec(planEvent.Data.Id)
+			if err != nil {
+				return model.PlanFailed, fmt.Errorf("failed to delete job config: %w", err)
+			}
+			if !removed {
+				return model.PlanSkipped, nil
+			}
+			status = model.PlanRemoved
+			break
+		}
+		err = cm.writeJobSpec(planEvent.Data)
+		if err != nil {
+			return model.PlanFailed, fmt.Errorf("failed to write job config: %w", err)
+		}
+		if planEvent.Type == model.PlanRolledBack {
+			err = setRolledBackFrom(planEvent.Data.Id, replaced)
+			if err != nil {
+				return model.PlanFailed, fmt.Errorf("failed to record rollback: %w", err)
+			}
+		}
+		// Download before rescheduling, so the updated jobs find their plugins in place
+		downloadErr = registry.DownloadPackages(planEvent.Data.Packages())
+		if downloadErr != nil {
+			log.Errorf("Error downloading some of the plugins: %s", downloadErr)
+			status = model.PlanDownloadFailed
+		}
+		if planEvent.Data.Paused {
+			status = model.PlanPausedStatus
+		}
+	case model.PlanDeleted, model.PlanDeactivated:
+		err := cm.removeJobSpec(planEvent.Data.Id)
+		if err != nil {
+			return model.PlanFailed, fmt.Errorf("failed to delete job config: %w", err)
+		}
+		status = model.PlanRemoved
+	case model.PlanPaused, model.PlanResumed:
+		err := cm.setPaused(planEvent.Data.Id, planEvent.Type == model.PlanPaused)
+		if err != nil {
+			return model.PlanInvalid, err
+		}
+		if planEvent.Type == model.PlanPaused {
+			status = model.PlanPausedStatus
+		}
+	default:
+		return model.PlanInvalid, fmt.Errorf("unknown plan event type %q", planEvent.Type)
+	}
+
+	err := cm.loadJobSpecs(assessmentPath)
+	if err != nil {
+		return model.PlanFailed, fmt.Errorf("failed to load job specs: %w", err)
+	}
+
+	// The scheduler replaces its jobs and cancels the runs of plans that were removed, paused or changed.
+	pubsub.Publish(pubsub.Event{
+		Type: pubsub.ConfigurationUpdated,
+		Data: cm.JobSpecs(),
+	})
+	return status, downloadErr
+}
+
+// setETag sets the ETag sent to the control plane to only fetch plans when they have changed.
+func (cm *ConfigurationManager) setETag(etag string) {
+	cm.mu.Lock()
+	cm.etag = etag
+	cm.mu.Unlock()
+}
+
+// getJobSpecs fetches the plans targeted at this runtime from the control plane, along with their ETag.
+// The ETag is not kept, that is up to the caller once the plans have been applied.
+func (cm *ConfigurationManager) getJobSpecs() ([]model.JobSpec, string, bool, error) {
+	// The runtime id and labels let the control plane return only the plans targeted at this runtime.
+	query := url.Values{}
+	query.Set("runtimeId", cm.config.RuntimeId)
+	for key, value := range cm.Labels() {
+		query.Add("label", key+"="+value)
+	}
+
+	req := cm.client.R().SetQueryParamsFromValues(query)
+	cm.mu.RLock()
+	if cm.etag != "" {
+		req.SetHeader("If-None-Match", cm.etag)
+	}
+	cm.mu.RUnlock()
+
+	resp, err := req.Get(cm.config.ControlPlaneURL + "/runtime/jobs")
+	if err != nil {
+		return nil, "", false, err
+	}
+	if resp.StatusCode() == http.StatusNotModified {
+		return nil, "", false, nil
 	}
 
 	var jobs []model.JobSpec
-	err = json.Unmarshal(resp.Body(), &jobs)
+	err = decodeResponse(resp, &jobs)
 	if err != nil {
-		return nil, err
+		return nil, "", false, fmt.Errorf("failed to get job configurations: %w", err)
 	}
 
-	return jobs, nil
+	targeted := make([]model.JobSpec, 0, len(jobs))
+	for _, job := range jobs {
+		if cm.targets(job) {
+			targeted = append(targeted, job)
+		}
+	}
+
+	return targeted, resp.Header().Get("ETag"), true, nil
+}
+
+// jobSpecPath returns the path of the file the job spec with the id is stored in.
+func jobSpecPath(id string) (string, error) {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid job id %q", id)
+	}
+	return filepath.Join(assessmentPath, id+".yaml"), nil
 }
 
 func (cm *ConfigurationManager) writeJobSpec(jobConfig model.JobSpec) error {
-	execDir, err := getExecutableDir()
+	path, err := jobSpecPath(jobConfig.Id)
 	if err != nil {
 		return err
 	}
 
-	configPath := filepath.Join(execDir, "assessments")
-
 	data, err := yaml.Marshal(jobConfig)
 	if err != nil {
 		return fmt.Errorf("failed to marshal yaml data: %w", err)
 	}
 
-	err = os.WriteFile(filepath.Join(configPath, jobConfig.Id+".yaml"), data, 0644)
+	err = cm.writePlanFile(path, data)
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
@@ -159,9 +646,54 @@ func (cm *ConfigurationManager) writeJobSpec(jobConfig model.JobSpec) error {
 	return nil
 }
 
-func (cm *ConfigurationManager) writeJobSpecs(jobConfigs []model.JobSpec) error {
-	for _, jobConfig := range jobConfigs {
-		err := cm.writeJobSpec(jobConfig)
+// removeJobSpec removes the job spec from disk. Removing a job spec that does not exist is not an error.
+func (cm *ConfigurationManager) removeJobSpec(id string) error {
+	path, err := jobSpecPath(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return clearRollback(id)
+}
+
+// removeInstalledJobSpec removes the job spec from disk, returning false if it was not installed.
+func (cm *ConfigurationManager) removeInstalledJobSpec(id string) (bool, error) {
+	path, err := jobSpecPath(id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+	return true, cm.removeJobSpec(id)
+}
+
+// removeStaleJobSpecs removes the job specs stored on disk that are not among the given ones.
+func (cm *ConfigurationManager) removeStaleJobSpecs(jobs []model.JobSpec) error {
+	keep := make(map[string]bool)
+	for _, job := range jobs {
+		keep[job.Id+".yaml"] = true
+	}
+
+	files, err := os.ReadDir(assessmentPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, file := range files {
+		fileExt := filepath.Ext(file.Name())
+		if (fileExt != ".yaml" && fileExt != ".yml") || keep[file.Name()] {
+			continue
+		}
+		log.WithField("file", file.Name()).Info("Removing job config no longer known to the control plane")
+		err = os.Remove(filepath.Join(assessmentPath, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to remove stale job config: %w", err)
+		}
+		err = clearRollback(strings.TrimSuffix(file.Name(), fileExt))
 		if err != nil {
 			return err
 		}
@@ -169,17 +701,38 @@ func (cm *ConfigurationManager) writeJobSpecs(jobConfigs []model.JobSpec) error
 	return nil
 }
 
-func (cm *ConfigurationManager) loadConfig(path string) error {
-	data, err := os.ReadFile(path)
+// setPaused pauses or resumes the job spec stored on disk.
+func (cm *ConfigurationManager) setPaused(id string, paused bool) error {
+	path, err := jobSpecPath(id)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return err
 	}
 
-	err = yaml.Unmarshal(data, &cm.config)
+	data, err := cm.readPlanFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("job %s is not installed", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var jobConfig model.JobSpec
+	err = yaml.Unmarshal(data, &jobConfig)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal yaml data: %w", err)
 	}
 
+	jobConfig.Paused = paused
+	return cm.writeJobSpec(jobConfig)
+}
+
+func (cm *ConfigurationManager) writeJobSpecs(jobConfigs []model.JobSpec) error {
+	for _, jobConfig := range jobConfigs {
+		err := cm.writeJobSpec(jobConfig)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -189,26 +742,41 @@ func (cm *ConfigurationManager) loadJobSpecs(path string) error {
 		return fmt.Errorf("failed to read directory: %w", err)
 	}
 
-	cm.jobSpecs = make([]model.JobSpec, 0)
+	jobSpecs := make([]model.JobSpec, 0)
 
 	for _, file := range files {
 		fileExt := filepath.Ext(file.Name())
 		if fileExt == ".yaml" || fileExt == ".yml" {
-			data, err := os.ReadFile(filepath.Join(path, file.Name()))
+			data, err := cm.readPlanFile(filepath.Join(path, file.Name()))
+			if os.IsNotExist(err) {
+				// Removed since the directory was read.
+				continue
+			}
+			if err != nil {
+				log.WithField("file", file.Name()).Errorf("Skipping unreadable job spec: %s", err)
+				continue
+			}
+
+			// Invalid specs are left out rather than failing the others.
+			config, err := parseJobSpec(file.Name(), data)
 			if err != nil {
-				return fmt.Errorf("failed to read file: %w", err)
+				log.WithField("file", file.Name()).Errorf("Skipping invalid job spec: %s", err)
+				continue
 			}
 
-			var config model.JobSpec
-			err = yaml.Unmarshal(data, &config)
+			err = cm.recordRevision(config)
 			if err != nil {
-				return fmt.Errorf("failed to unmarshal yaml data: %w", err)
+				log.WithField("file", file.Name()).Warnf("Failed to record job spec revision: %s", err)
 			}
 
-			cm.jobSpecs = append(cm.jobSpecs, config)
+			jobSpecs = append(jobSpecs, config)
 		}
 	}
 
+	cm.mu.Lock()
+	cm.jobSpecs = jobSpecs
+	cm.mu.Unlock()
+
 	return nil
 }
 
@@ -219,18 +787,11 @@ func (cm *ConfigurationManager) Config() Config {
 func (cm *ConfigurationManager) Packages() []model.Package {
 	pluginInfoMap := make(map[string]model.Package)
 
-	for _, jobSpec := range cm.jobSpecs {
-		for _, task := range jobSpec.Tasks {
-			for _, activity := range task.Activities {
-				key := activity.Provider.Name + activity.Provider.Tag
-				if _, exists := pluginInfoMap[key]; !exists {
-					info := model.Package{
-						Name:    activity.Provider.Name,
-						Tag:     activity.Provider.Tag,
-						Image:   activity.Provider.Image,
-					}
-					pluginInfoMap[key] = info
-				}
+	for _, jobSpec := range cm.JobSpecs() {
+		for _, info := range jobSpec.Packages() {
+			key := info.Name + info.Tag
+			if _, exists := pluginInfoMap[key]; !exists {
+				pluginInfoMap[key] = info
 			}
 		}
 	}
@@ -244,5 +805,8 @@ func (cm *ConfigurationManager) Packages() []model.Package {
 }
 
 func (cm *ConfigurationManager) JobSpecs() []model.JobSpec {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
 	return cm.jobSpecs
 }
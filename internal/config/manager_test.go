@@ -1,67 +1,374 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/compliance-framework/assessment-runtime/internal/event"
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
+	natsserver "github.com/nats-io/nats-server/v2/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadConfig(t *testing.T) {
 	configYml := `
 runtimeId: "123e4567-e89b-12d3-a456-426614174000"
-controlPlaneAPI: "http://localhost:1234"
+controlPlaneURL: "http://localhost:1234"
 eventBusURL: "nats://nats:4222"
 `
 
 	assessmentYml := `
-assessment-id: "assess-1234"
-ssp-id: "ssp-5678"
+id: "assess-1234"
+assessment-plan-id: "plan-5678"
 control-id: "ctrl-9101"
 component-id: "comp-1121"
-schedule: "*/10 * * * * *"
-plugins:
-  - name: "do-nothing"
-    package: "sample"
-    tag: "latest"
-    configuration:
-		config1: "value1"
-		config2: "value2"
-		config3: "value3"
-	parameters:
-		param1: "value1"
-		param2: "value2"
-		param3: "value3"
+tasks:
+  - id: "task-1"
+    schedule: "*/10 * * * * *"
+    activities:
+      - id: "activity-1"
+        provider:
+          name: "do-nothing"
+          image: "ghcr.io/compliance-framework/do-nothing"
+          tag: "latest"
+          configuration:
+            config1: "value1"
 `
 
-	tmpCfg, err := os.CreateTemp("", "config.yml")
-	if err != nil {
-		t.Fatal(err)
+	dataDir := t.TempDir()
+	configFile := filepath.Join(dataDir, "config.yml")
+	require.NoError(t, os.WriteFile(configFile, []byte(configYml), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, "assessments"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "assessments", "assess-1234.yaml"), []byte(assessmentYml), 0644))
+
+	// The control plane is not running, so the plans are loaded from the assessments directory.
+	args := []string{"-config", configFile, "-data-dir", dataDir, "-controlPlane.retries", "-1"}
+	settings, err := loadSettings(args, func(string) (string, bool) { return "", false }, io.Discard)
+	require.NoError(t, err)
+
+	cm, err := NewConfigurationManagerFromSettings(settings)
+	require.NoError(t, err)
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174000", cm.Config().RuntimeId)
+	require.Len(t, cm.JobSpecs(), 1)
+	assert.Equal(t, "assess-1234", cm.JobSpecs()[0].Id)
+}
+
+func TestPlanLifecycle(t *testing.T) {
+	isolate(t)
+	cm := &ConfigurationManager{}
+
+	updates := subscribe(t, pubsub.ConfigurationUpdated)
+	nextSpecs := func() []model.JobSpec {
+		return (<-updates).Data.([]model.JobSpec)
+	}
+	apply := func(eventType string, spec model.JobSpec) model.PlanStatus {
+		status, err := cm.apply(model.PlanEvent{Type: eventType, Data: spec})
+		require.NoError(t, err)
+		return status
 	}
 
-	tmpAssessCfg, err := os.CreateTemp("assessments", "config.yml")
-	if err != nil {
-		t.Fatal(err)
+	binary := filepath.Join(t.TempDir(), "plugin")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+	plan := model.JobSpec{Id: "plan-1", Title: "Plan", Tasks: []model.Task{{
+		Id:       "task-1",
+		Schedule: "*/10 * * * * *",
+		Activities: []model.Activity{{
+			Id:       "activity-1",
+			Provider: model.Provider{Name: "lifecycle-test", Image: "file://" + binary, Tag: "1.0.0", Configuration: map[string]string{}},
+		}},
+	}}}
+	assert.Equal(t, model.PlanScheduled, apply(model.PlanActivated, plan))
+	assert.Equal(t, []model.JobSpec{plan}, nextSpecs())
+
+	assert.Equal(t, model.PlanPausedStatus, apply(model.PlanPaused, model.JobSpec{Id: plan.Id}))
+	specs := nextSpecs()
+	require.Len(t, specs, 1)
+	assert.True(t, specs[0].Paused)
+
+	assert.Equal(t, model.PlanScheduled, apply(model.PlanResumed, model.JobSpec{Id: plan.Id}))
+	assert.Equal(t, []model.JobSpec{plan}, nextSpecs())
+
+	assert.Equal(t, model.PlanRemoved, apply(model.PlanDeleted, model.JobSpec{Id: plan.Id}))
+	assert.Empty(t, nextSpecs())
+	_, err := os.Stat(filepath.Join(assessmentPath, plan.Id+".yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	status, err := cm.apply(model.PlanEvent{Type: model.PlanPaused, Data: model.JobSpec{Id: plan.Id}})
+	assert.Error(t, err, "a deleted plan cannot be paused")
+	assert.Equal(t, model.PlanInvalid, status)
+
+	status, err = cm.apply(model.PlanEvent{Type: model.PlanDeleted, Data: model.JobSpec{Id: "../config"}})
+	assert.Error(t, err)
+	assert.Equal(t, model.PlanFailed, status)
+
+	invalid := model.JobSpec{Id: "plan-2", Tasks: []model.Task{{Id: "task-1", Schedule: "every minute"}}}
+	status, err = cm.apply(model.PlanEvent{Type: model.PlanActivated, Data: invalid})
+	assert.Error(t, err)
+	assert.Equal(t, model.PlanInvalid, status)
+
+	status, err = cm.apply(model.PlanEvent{Type: "archived", Data: plan})
+	assert.Error(t, err)
+	assert.Equal(t, model.PlanInvalid, status)
+}
+
+func TestResync(t *testing.T) {
+	plans := []model.JobSpec{{Id: "plan-1", Title: "Plan"}, {Id: "plan-2", Title: "Updated"}}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "test-runtime", r.URL.Query().Get("runtimeId"))
+		if requests == 1 {
+			// Failures of the control plane are retried.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_ = json.NewEncoder(w).Encode(plans)
+	}))
+	defer server.Close()
+
+	client, err := newClient(ControlPlaneOptions{})
+	require.NoError(t, err)
+
+	cm := &ConfigurationManager{
+		config: Config{RuntimeId: "test-runtime", ControlPlaneURL: server.URL},
+		client: client.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(time.Millisecond),
+		jobSpecs: []model.JobSpec{
+			{Id: "plan-2", Title: "Plan"},
+			{Id: "plan-3", Title: "Removed"},
+		},
 	}
 
-	defer func() {
-		_ = os.Remove(tmpCfg.Name())
-		_ = os.Remove(tmpAssessCfg.Name())
-	}()
+	jobs, etag, modified, err := cm.getJobSpecs()
+	require.NoError(t, err)
+	assert.True(t, modified)
+	assert.Equal(t, plans, jobs)
+	assert.Equal(t, `"v1"`, etag)
+
+	_, _, modified, err = cm.getJobSpecs()
+	require.NoError(t, err)
+	assert.True(t, modified, "the ETag is only sent once the plans have been applied")
+
+	cm.setETag(etag)
+	_, _, modified, err = cm.getJobSpecs()
+	require.NoError(t, err)
+	assert.False(t, modified, "unchanged plans are not sent again")
+
+	assert.ElementsMatch(t, []model.PlanEvent{
+		{Type: model.PlanActivated, Data: plans[0]},
+		{Type: model.PlanUpdated, Data: plans[1]},
+		{Type: model.PlanDeleted, Data: model.JobSpec{Id: "plan-3"}},
+	}, cm.diff(jobs))
+}
 
-	if _, err := tmpCfg.Write([]byte(configYml)); err != nil {
-		t.Fatal(err)
+func TestResyncKeepsPaused(t *testing.T) {
+	cm := &ConfigurationManager{jobSpecs: []model.JobSpec{{Id: "plan-1", Title: "Plan", Paused: true}}}
+
+	assert.Empty(t, cm.diff([]model.JobSpec{{Id: "plan-1", Title: "Plan"}}), "pausing a plan is not undone by the control plane's copy")
+	assert.Equal(t, []model.PlanEvent{
+		{Type: model.PlanUpdated, Data: model.JobSpec{Id: "plan-1", Title: "Updated", Paused: true}},
+	}, cm.diff([]model.JobSpec{{Id: "plan-1", Title: "Updated"}}))
+}
+
+func TestResyncFailure(t *testing.T) {
+	assessmentPath = t.TempDir()
+	invalid := model.JobSpec{Id: "plan-1", Tasks: []model.Task{{Id: "task-1", Schedule: "every minute"}}}
+	var mu sync.Mutex
+	var etags []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		etags = append(etags, r.Header.Get("If-None-Match"))
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		_ = json.NewEncoder(w).Encode([]model.JobSpec{invalid})
+	}))
+	defer server.Close()
+
+	client, err := newClient(ControlPlaneOptions{})
+	require.NoError(t, err)
+	cm := &ConfigurationManager{
+		config: Config{RuntimeId: "test-runtime", ControlPlaneURL: server.URL, ResyncInterval: 10 * time.Millisecond},
+		client: client,
 	}
-	if err := tmpCfg.Close(); err != nil {
-		t.Fatal(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cm.Resync(ctx)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(etags) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, etag := range etags {
+		assert.Empty(t, etag, "a plan that failed to apply is fetched again")
 	}
-	if _, err := tmpAssessCfg.Write([]byte(assessmentYml)); err != nil {
-		t.Fatal(err)
+}
+
+func TestUndecodableEvent(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+	require.NoError(t, event.Connect(s.ClientURL(), event.Options{}))
+	defer event.Close()
+
+	cm := &ConfigurationManager{config: Config{RuntimeId: "test-runtime"}}
+	acks, err := event.Subscribe[model.PlanAck](cm.Topic("configuration.ack"))
+	require.NoError(t, err)
+	replies, err := event.Subscribe[model.PlanAck]("reply")
+	require.NoError(t, err)
+
+	err = cm.handle(event.Request[model.PlanEvent]{Reply: "reply", Err: errors.New("failed to decode message")})
+	assert.Error(t, err)
+
+	for _, ch := range []chan model.PlanAck{acks, replies} {
+		select {
+		case ack := <-ch:
+			assert.Equal(t, model.PlanInvalid, ack.Status)
+			assert.Equal(t, "failed to decode message", ack.Error)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the event was not acknowledged")
+		}
 	}
-	if err := tmpAssessCfg.Close(); err != nil {
-		t.Fatal(err)
+}
+
+func TestPlanRetargeted(t *testing.T) {
+	assessmentPath = t.TempDir()
+	cm := &ConfigurationManager{config: Config{Labels: map[string]string{"region": "eu"}}}
+
+	binary := filepath.Join(t.TempDir(), "plugin")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+	plan := func(region string) model.JobSpec {
+		return model.JobSpec{
+			Id:              "plan-1",
+			RuntimeSelector: &model.RuntimeSelector{MatchLabels: map[string]string{"region": region}},
+			Tasks: []model.Task{{
+				Id:       "task-1",
+				Schedule: "*/10 * * * * *",
+				Activities: []model.Activity{{
+					Id:       "activity-1",
+					Provider: model.Provider{Name: "retarget-test", Image: "file://" + binary, Tag: "1.0.0", Configuration: map[string]string{}},
+				}},
+			}},
+		}
+	}
+	apply := func(eventType string, spec model.JobSpec) model.PlanStatus {
+		status, err := cm.apply(model.PlanEvent{Type: eventType, Data: spec})
+		require.NoError(t, err)
+		return status
 	}
 
-	_, err = NewConfigurationManager()
-	assert.Nil(t, err)
+	assert.Equal(t, model.PlanScheduled, apply(model.PlanActivated, plan("eu")))
+	assert.Len(t, cm.JobSpecs(), 1)
+
+	// The plan moves to another runtime, so it stops here.
+	assert.Equal(t, model.PlanRemoved, apply(model.PlanUpdated, plan("us")))
+	assert.Empty(t, cm.JobSpecs())
+	_, err := os.Stat(filepath.Join(assessmentPath, "plan-1.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, model.PlanSkipped, apply(model.PlanUpdated, plan("us")))
+}
+
+func TestPluginLabels(t *testing.T) {
+	isolate(t)
+	cm := &ConfigurationManager{}
+	t.Cleanup(cm.watchPlugins())
+	assert.NotContains(t, cm.Labels(), "plugin.labels-test")
+
+	binary := filepath.Join(t.TempDir(), "plugin")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+	require.NoError(t, registry.DownloadPackages([]model.Package{{Name: "labels-test", Image: "file://" + binary, Tag: "1.0.0"}}))
+
+	assert.Eventually(t, func() bool {
+		return cm.Labels()["plugin.labels-test"] == "1.0.0"
+	}, 5*time.Second, 10*time.Millisecond, "the labels are refreshed once plugins are installed")
+}
+
+func TestLoadControlPlanePlans(t *testing.T) {
+	valid := model.JobSpec{Id: "plan-1", Tasks: []model.Task{{
+		Id:       "task-1",
+		Schedule: "0 * * * * *",
+		Activities: []model.Activity{{
+			Id:       "activity-1",
+			Provider: model.Provider{Name: "busy", Image: "ghcr.io/compliance-framework/busy", Tag: "1.0.0", Configuration: map[string]string{}},
+		}},
+	}}}
+	invalid := model.JobSpec{Id: "plan-2", Tasks: []model.Task{{Id: "task-1", Schedule: "every minute"}}}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_ = json.NewEncoder(w).Encode([]model.JobSpec{valid, invalid})
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+	configFile := filepath.Join(dataDir, "config.yml")
+	require.NoError(t, os.WriteFile(configFile, []byte("runtimeId: test-runtime\ncontrolPlaneURL: "+server.URL+"\n"), 0644))
+	args := []string{"-data-dir", dataDir, "-config", configFile}
+	settings, err := loadSettings(args, func(string) (string, bool) { return "", false }, io.Discard)
+	require.NoError(t, err)
+
+	cm, err := NewConfigurationManagerFromSettings(settings)
+	require.NoError(t, err)
+	assert.Equal(t, []model.JobSpec{valid}, cm.JobSpecs(), "invalid plans from the control plane are not scheduled")
+
+	revisions, err := cm.History(valid.Id)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, valid.Revision(), revisions[0].Revision)
+
+	// The commands working on the local plans neither contact the control plane nor remove the plans it lacks.
+	local := valid
+	local.Id = "plan-3"
+	require.NoError(t, cm.writeJobSpec(local))
+	cm, err = NewLocalConfigurationManager(settings)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.ElementsMatch(t, []model.JobSpec{valid, local}, cm.JobSpecs())
+}
+
+// isolate points the assessments and plugins directories at temporary directories for the duration of the test.
+func isolate(t *testing.T) {
+	t.Helper()
+	previous := assessmentPath
+	assessmentPath = t.TempDir()
+	require.NoError(t, registry.Configure(registry.Options{Dir: t.TempDir()}))
+	t.Cleanup(func() {
+		assessmentPath = previous
+		_ = registry.Configure(registry.Options{})
+	})
+}
+
+// subscribe subscribes to the topic for the duration of the test.
+func subscribe(t *testing.T, topic pubsub.EventType) <-chan pubsub.Event {
+	t.Helper()
+	ch, err := pubsub.Subscribe(topic)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		pubsub.Unsubscribe(topic, ch)
+	})
+	return ch
 }
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables overriding the configuration, e.g. AR_RUNTIME_ID.
+const envPrefix = "AR_"
+
+// Settings is the effective configuration of the runtime and the paths it was loaded from.
+//
+// Values are taken from, in increasing order of precedence: the defaults, the configuration file, the AR_*
+// environment variables and the command line flags. Every configuration value has a flag named after its
+// path in the configuration file and an environment variable named after the same path in upper snake case,
+// e.g. -registry.gcInterval and AR_REGISTRY_GC_INTERVAL.
+type Settings struct {
+	Config Config
+	// ConfigFile is the configuration file, config.yml next to the executable by default.
+	// It is set with -config or AR_CONFIG.
+	ConfigFile string
+	// DataDir holds the assessments directory and, unless registry.dir is set, the plugins directory.
+	// It defaults to the directory of the executable and is set with -data-dir or AR_DATA_DIR.
+	DataDir string
+	// PrintConfig is set with -print-config to print the effective configuration instead of running.
+	PrintConfig bool
+}
+
+// AssessmentsDir is the directory the plans are stored in.
+func (s Settings) AssessmentsDir() string {
+	return filepath.Join(s.DataDir, "assessments")
+}
+
+// LoadSettings resolves the effective configuration from the configuration file, the environment and args,
+// the command line flags without the program name.
+func LoadSettings(args []string) (Settings, error) {
+	return loadSettings(args, os.LookupEnv, os.Stderr)
+}
+
+func loadSettings(args []string, lookupEnv func(string) (string, bool), output io.Writer) (Settings, error) {
+	var settings Settings
+
+	flags := flag.NewFlagSet("runtime", flag.ContinueOnError)
+	flags.SetOutput(output)
+	configFile := flags.String("config", "", "path of the configuration file (env AR_CONFIG)")
+	dataDir := flags.String("data-dir", "", "directory holding the assessments and plugins (env AR_DATA_DIR)")
+	flags.BoolVar(&settings.PrintConfig, "print-config", false, "print the effective configuration and exit")
+
+	paths := configPaths(reflect.TypeOf(Config{}), "")
+	for _, path := range paths {
+		flags.String(path, "", fmt.Sprintf("overrides %s (env %s)", path, envName(path)))
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return settings, err
+	}
+	if flags.NArg() > 0 {
+		return settings, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	execDir, err := getExecutableDir()
+	if err != nil {
+		return settings, err
+	}
+	settings.DataDir = firstNonEmpty(*dataDir, envValue(lookupEnv, "AR_DATA_DIR"), execDir)
+	settings.ConfigFile = firstNonEmpty(*configFile, envValue(lookupEnv, "AR_CONFIG"), filepath.Join(execDir, "config.yml"))
+
+	data, err := os.ReadFile(settings.ConfigFile)
+	if err != nil {
+		return settings, fmt.Errorf("failed to read config file: %w", err)
+	}
+	err = yaml.Unmarshal(data, &settings.Config)
+	if err != nil {
+		return settings, fmt.Errorf("failed to unmarshal yaml data: %w", err)
+	}
+
+	for _, path := range paths {
+		if value, ok := lookupEnv(envName(path)); ok {
+			err = setConfigValue(&settings.Config, path, value)
+			if err != nil {
+				return settings, fmt.Errorf("invalid %s: %w", envName(path), err)
+			}
+		}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if err != nil || !hasPath(paths, f.Name) {
+			return
+		}
+		err = setConfigValue(&settings.Config, f.Name, f.Value.String())
+		if err != nil {
+			err = fmt.Errorf("invalid -%s: %w", f.Name, err)
+		}
+	})
+	if err != nil {
+		return settings, err
+	}
+
+	if settings.Config.Registry.Dir == "" {
+		settings.Config.Registry.Dir = filepath.Join(settings.DataDir, "plugins")
+	}
+	if settings.Config.EventBusServer.StoreDir == "" {
+		settings.Config.EventBusServer.StoreDir = filepath.Join(settings.DataDir, "jetstream")
+	}
+
+	return settings, nil
+}
+
+// Dump returns the effective configuration as YAML, with secrets masked.
+func (s Settings) Dump() (string, error) {
+	config := s.Config
+	maskSecrets(reflect.ValueOf(&config).Elem())
+
+	data, err := yaml.Marshal(struct {
+		ConfigFile string `yaml:"configFile"`
+		DataDir    string `yaml:"dataDir"`
+		Config     Config `yaml:"config"`
+	}{s.ConfigFile, s.DataDir, config})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	return string(data), nil
+}
+
+func envValue(lookupEnv func(string) (string, bool), name string) string {
+	value, _ := lookupEnv(name)
+	return value
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func hasPath(paths []string, name string) bool {
+	for _, path := range paths {
+		if path == name {
+			return true
+		}
+	}
+	return false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configPaths returns the paths of all values of the configuration type, joined by dots, e.g. registry.gcInterval.
+func configPaths(t reflect.Type, prefix string) []string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	paths := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && fieldType != durationType {
+			paths = append(paths, configPaths(fieldType, prefix+name+".")...)
+			continue
+		}
+		paths = append(paths, prefix+name)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func yamlName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// envName returns the environment variable for the configuration path, e.g. AR_CONTROL_PLANE_URL for controlPlaneURL.
+func envName(path string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+
+	runes := []rune(path)
+	for i, r := range runes {
+		if r == '.' {
+			b.WriteRune('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// setConfigValue parses value into the configuration value at path.
+func setConfigValue(config *Config, path string, value string) error {
+	v := reflect.ValueOf(config).Elem()
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if yamlName(v.Type().Field(i)) == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown configuration %s", path)
+		}
+	}
+
+	return parseValue(v, value)
+}
+
+func parseValue(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		// A comma separated list, e.g. localhost:5000,registry.internal
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		// Comma separated key=value pairs, e.g. region=eu-west-1,cloud=aws
+		items := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			items[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("unsupported configuration type " + v.Type().String())
+	}
+	return nil
+}
+
+// maskSecrets replaces the secrets in v, which are the strings hidden from JSON, with asterisks.
+func maskSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			// Copy, so the original configuration is not masked.
+			masked := reflect.New(v.Type().Elem())
+			masked.Elem().Set(v.Elem())
+			maskSecrets(masked.Elem())
+			v.Set(masked)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Tag.Get("json") == "-" && field.Type.Kind() == reflect.String {
+				if v.Field(i).String() != "" {
+					v.Field(i).SetString("********")
+				}
+				continue
+			}
+			maskSecrets(v.Field(i))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+runtimeId: from-file
+eventBusURL: nats://file:4222
+controlPlaneURL: http://file
+registry:
+  gcInterval: 2h
+controlPlane:
+  token: file-token
+`), 0644))
+
+	env := map[string]string{
+		"AR_CONFIG":                         configFile,
+		"AR_DATA_DIR":                       dir,
+		"AR_EVENT_BUS_URL":                  "nats://env:4222",
+		"AR_CONTROL_PLANE_URL":              "http://env",
+		"AR_REGISTRY_GC_INTERVAL":           "3h",
+		"AR_REGISTRY_MIRRORS":               "ghcr.io=mirror.internal/ghcr",
+		"AR_CONTROL_PLANE_OAUTH2_CLIENT_ID": "runtime",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	settings, err := loadSettings([]string{"-controlPlaneURL", "http://flag", "-labels", "region=eu-west-1, cloud=aws"}, lookupEnv, io.Discard)
+	require.NoError(t, err)
+
+	assert.Equal(t, configFile, settings.ConfigFile)
+	assert.Equal(t, filepath.Join(dir, "assessments"), settings.AssessmentsDir())
+	assert.Equal(t, filepath.Join(dir, "plugins"), settings.Config.Registry.Dir)
+
+	assert.Equal(t, "from-file", settings.Config.RuntimeId, "the file overrides the defaults")
+	assert.Equal(t, "nats://env:4222", settings.Config.EventBusURL, "the environment overrides the file")
+	assert.Equal(t, "http://flag", settings.Config.ControlPlaneURL, "flags override the environment")
+	assert.Equal(t, 3*time.Hour, settings.Config.Registry.GCInterval)
+	assert.Equal(t, map[string]string{"ghcr.io": "mirror.internal/ghcr"}, settings.Config.Registry.Mirrors)
+	assert.Equal(t, map[string]string{"region": "eu-west-1", "cloud": "aws"}, settings.Config.Labels)
+	require.NotNil(t, settings.Config.ControlPlane.OAuth2)
+	assert.Equal(t, "runtime", settings.Config.ControlPlane.OAuth2.ClientID)
+
+	dump, err := settings.Dump()
+	require.NoError(t, err)
+	assert.Contains(t, dump, "controlPlaneURL: http://flag")
+	assert.NotContains(t, dump, "file-token", "secrets are masked")
+	assert.Equal(t, "file-token", settings.Config.ControlPlane.Token, "masking does not change the configuration")
+
+	_, err = loadSettings([]string{"-registry.retries", "many"}, lookupEnv, io.Discard)
+	assert.Error(t, err)
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "AR_RUNTIME_ID", envName("runtimeId"))
+	assert.Equal(t, "AR_CONTROL_PLANE_URL", envName("controlPlaneURL"))
+	assert.Equal(t, "AR_CONTROL_PLANE_OAUTH2_TOKEN_URL", envName("controlPlane.oauth2.tokenURL"))
+	assert.Equal(t, "AR_REGISTRY_GC_GRACE_PERIOD", envName("registry.gcGracePeriod"))
+	assert.Equal(t, "AR_EVENT_BUS_NKEY_FILE", envName("eventBus.nkeyFile"))
+}
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/secret"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// scheduleParser parses task schedules the way the scheduler does, with a leading seconds field.
+var scheduleParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// knownOperators are the selector expression operators understood by the runtime and the plugins.
+var knownOperators = map[string]bool{
+	model.OperatorIn:           true,
+	model.OperatorNotIn:        true,
+	model.OperatorExists:       true,
+	model.OperatorDoesNotExist: true,
+}
+
+// Problem is a single reason a job spec is invalid. Line and Column are zero when the spec was not read from a file.
+type Problem struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	location := ""
+	if p.Line > 0 {
+		location = fmt.Sprintf("%d:%d: ", p.Line, p.Column)
+	}
+	if p.Path == "" {
+		return location + p.Message
+	}
+	return location + p.Path + ": " + p.Message
+}
+
+// ValidationError lists all problems found in a job spec.
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		if e.File != "" && problem.Line > 0 {
+			lines = append(lines, e.File+":"+problem.String())
+		} else {
+			lines = append(lines, problem.String())
+		}
+	}
+	return fmt.Sprintf("invalid job spec: %s", strings.Join(lines, "; "))
+}
+
+// validator collects the problems of a job spec, locating them in the YAML document it was read from, if any.
+type validator struct {
+	root     *yaml.Node
+	problems []Problem
+}
+
+// addf records a problem at the path, given as field names and slice indexes.
+func (v *validator) addf(path []any, format string, args ...any) {
+	problem := Problem{Path: formatPath(path), Message: fmt.Sprintf(format, args...)}
+	if node := v.locate(path); node != nil {
+		problem.Line, problem.Column = node.Line, node.Column
+	}
+	v.problems = append(v.problems, problem)
+}
+
+// locate returns the node at the path, or the closest of its parents that exists.
+func (v *validator) locate(path []any) *yaml.Node {
+	node := v.root
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, segment := range path {
+		var next *yaml.Node
+		switch s := segment.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == s {
+						next = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && s < len(node.Content) {
+				next = node.Content[s]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+func formatPath(path []any) string {
+	var b strings.Builder
+	for _, segment := range path {
+		switch s := segment.(type) {
+		case string:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(s)
+		case int:
+			b.WriteString("[" + strconv.Itoa(s) + "]")
+		}
+	}
+	return b.String()
+}
+
+func appendPath(path []any, segments ...any) []any {
+	return append(append(make([]any, 0, len(path)+len(segments)), path...), segments...)
+}
+
+// unknownFields reports the keys of the mapping nodes that do not match a field of the type they are decoded into.
+func (v *validator) unknownFields(node *yaml.Node, t reflect.Type, path []any) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.DocumentNode {
+		for _, child := range node.Content {
+			v.unknownFields(child, t, path)
+		}
+		return
+	}
+
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if name := yamlName(t.Field(i)); name != "" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				v.problems = append(v.problems, Problem{
+					Line:    key.Line,
+					Column:  key.Column,
+					Path:    formatPath(appendPath(path, key.Value)),
+					Message: "unknown field",
+				})
+				continue
+			}
+			v.unknownFields(node.Content[i+1], fieldType, appendPath(path, key.Value))
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.unknownFields(node.Content[i+1], t.Elem(), appendPath(path, node.Content[i].Value))
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for i, item := range node.Content {
+			v.unknownFields(item, t.Elem(), appendPath(path, i))
+		}
+	}
+}
+
+// validateSpec checks the content of the job spec.
+func (v *validator) validateSpec(spec model.JobSpec) {
+	if spec.Id == "" {
+		v.addf([]any{"id"}, "id is required")
+	} else if _, err := jobSpecPath(spec.Id); err != nil {
+		v.addf([]any{"id"}, "%s", err)
+	}
+
+	if len(spec.Tasks) == 0 {
+		v.addf([]any{"tasks"}, "at least one task is required")
+	}
+
+	taskIds := make(map[string]bool)
+	activityIds := make(map[string]bool)
+	for i, task := range spec.Tasks {
+		path := []any{"tasks", i}
+		if task.Id == "" {
+			v.addf(appendPath(path, "id"), "id is required")
+		} else if taskIds[task.Id] {
+			v.addf(appendPath(path, "id"), "duplicate task id %s", task.Id)
+		}
+		taskIds[task.Id] = true
+
+		if task.Schedule == "" {
+			v.addf(appendPath(path, "schedule"), "schedule is required")
+		} else if _, err := scheduleParser.Parse(task.Schedule); err != nil {
+			v.addf(appendPath(path, "schedule"), "invalid schedule %q: %s", task.Schedule, err)
+		}
+
+		// Map keys are sorted, so the problems are always reported in the same order.
+		for _, key := range sortedKeys(task.Matrix) {
+			if len(task.Matrix[key]) == 0 {
+				v.addf(appendPath(path, "matrix", key), "at least one value is required")
+			}
+		}
+
+		if len(task.Activities) == 0 {
+			v.addf(appendPath(path, "activities"), "at least one activity is required")
+		}
+		for j, activity := range task.Activities {
+			// Activities are looked up by id across the whole spec, so they must be unique across tasks.
+			activityPath := appendPath(path, "activities", j)
+			if activity.Id == "" {
+				v.addf(appendPath(activityPath, "id"), "id is required")
+			} else if activityIds[activity.Id] {
+				v.addf(appendPath(activityPath, "id"), "duplicate activity id %s", activity.Id)
+			}
+			activityIds[activity.Id] = true
+
+			providerPath := appendPath(activityPath, "provider")
+			if activity.Provider.Name == "" {
+				v.addf(appendPath(providerPath, "name"), "provider name is required")
+			}
+			if activity.Provider.Tag == "" {
+				v.addf(appendPath(providerPath, "tag"), "provider tag is required")
+			}
+			if activity.Provider.Image == "" {
+				v.addf(appendPath(providerPath, "image"), "provider image is required")
+			}
+			for _, key := range sortedKeys(activity.Provider.Configuration) {
+				value := activity.Provider.Configuration[key]
+				if err := secret.Check(value); err != nil {
+					v.addf(appendPath(providerPath, "configuration", key), "%s", err)
+				}
+				v.checkTemplate(appendPath(providerPath, "configuration", key), value)
+			}
+
+			selectorPath := appendPath(activityPath, "selector")
+			v.checkTemplate(appendPath(selectorPath, "query"), activity.Selector.Query)
+			for _, key := range sortedKeys(activity.Selector.Labels) {
+				v.checkTemplate(appendPath(selectorPath, "labels", key), activity.Selector.Labels[key])
+			}
+			for k, id := range activity.Selector.Ids {
+				v.checkTemplate(appendPath(selectorPath, "ids", k), id)
+			}
+			for k, expression := range activity.Selector.Expressions {
+				for l, value := range expression.Values {
+					v.checkTemplate(appendPath(selectorPath, "expressions", k, "values", l), value)
+				}
+			}
+
+			v.validateExpressions(activity.Selector.Expressions, appendPath(selectorPath, "expressions"))
+		}
+	}
+
+	if spec.RuntimeSelector != nil {
+		v.validateExpressions(spec.RuntimeSelector.MatchExpressions, []any{"runtime-selector", "matchExpressions"})
+	}
+}
+
+func (v *validator) checkTemplate(path []any, s string) {
+	if err := model.CheckTemplate(s); err != nil {
+		v.addf(path, "invalid template: %s", err)
+	}
+}
+
+func (v *validator) validateExpressions(expressions []model.Expression, path []any) {
+	for i, expression := range expressions {
+		if expression.Key == "" {
+			v.addf(appendPath(path, i, "key"), "key is required")
+		}
+		if !knownOperators[expression.Operator] {
+			v.addf(appendPath(path, i, "operator"), "unknown operator %q", expression.Operator)
+		}
+	}
+}
+
+func (v *validator) err(file string) error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{File: file, Problems: v.problems}
+}
+
+// validate checks a job spec received from the control plane.
+func validate(spec model.JobSpec) error {
+	v := &validator{}
+	v.validateSpec(spec)
+	return v.err("")
+}
+
+// parseJobSpec decodes and validates the job spec stored in file, reporting the problems with their lines.
+func parseJobSpec(file string, data []byte) (model.JobSpec, error) {
+	var spec model.JobSpec
+
+	var root yaml.Node
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return spec, &ValidationError{File: file, Problems: []Problem{{Message: err.Error()}}}
+	}
+
+	v := &validator{root: &root}
+	v.unknownFields(&root, reflect.TypeOf(spec), nil)
+
+	err = root.Decode(&spec)
+	if err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, message := range typeErr.Errors {
+				v.problems = append(v.problems, Problem{Message: message})
+			}
+		} else {
+			v.problems = append(v.problems, Problem{Message: err.Error()})
+		}
+		return spec, v.err(file)
+	}
+
+	v.validateSpec(spec)
+	return spec, v.err(file)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
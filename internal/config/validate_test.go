@@ -0,0 +1,102 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJobSpec(t *testing.T) {
+	data := []byte(`id: "plan-1"
+task-id: "task-1"
+tasks:
+  - id: "task-1"
+    schedule: "every minute"
+    activities:
+      - id: "activity-1"
+        selector:
+          expressions:
+            - key: "tag"
+              operator: "Like"
+        provider:
+          name: "busy"
+          package: "busy"
+          version: "1.0.0"
+      - id: "activity-1"
+        provider:
+          name: "busy"
+          image: "ghcr.io/compliance-framework/busy"
+          tag: "1.0.0"
+          configuration:
+            token: "${vault:azure/token}"
+            region: "{{ .Labels.region"
+`)
+
+	_, err := parseJobSpec("plan-1.yaml", data)
+	require.Error(t, err)
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "plan-1.yaml", validationErr.File)
+	assert.ElementsMatch(t, []Problem{
+		{Line: 2, Column: 1, Path: "task-id", Message: "unknown field"},
+		{Line: 14, Column: 11, Path: "tasks[0].activities[0].provider.package", Message: "unknown field"},
+		{Line: 15, Column: 11, Path: "tasks[0].activities[0].provider.version", Message: "unknown field"},
+		{Line: 5, Column: 15, Path: "tasks[0].schedule", Message: `invalid schedule "every minute": expected exactly 6 fields, found 2: [every minute]`},
+		{Line: 11, Column: 25, Path: "tasks[0].activities[0].selector.expressions[0].operator", Message: `unknown operator "Like"`},
+		{Line: 13, Column: 11, Path: "tasks[0].activities[0].provider.tag", Message: "provider tag is required"},
+		{Line: 13, Column: 11, Path: "tasks[0].activities[0].provider.image", Message: "provider image is required"},
+		{Line: 16, Column: 13, Path: "tasks[0].activities[1].id", Message: "duplicate activity id activity-1"},
+		{Line: 22, Column: 20, Path: "tasks[0].activities[1].provider.configuration.token", Message: `unknown secret provider "vault" in ${vault:azure/token}`},
+		{Line: 23, Column: 21, Path: "tasks[0].activities[1].provider.configuration.region", Message: "invalid template: template: :1: unclosed action"},
+	}, validationErr.Problems)
+	assert.Contains(t, err.Error(), "plan-1.yaml:2:1: task-id: unknown field")
+
+	spec, err := parseJobSpec("plan-1.yaml", []byte(`id: "plan-1"
+tasks:
+  - id: "task-1"
+    schedule: "0 * * * * *"
+    activities:
+      - id: "activity-1"
+        provider:
+          name: "busy"
+          image: "ghcr.io/compliance-framework/busy"
+          tag: "1.0.0"
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "busy", spec.Tasks[0].Activities[0].Provider.Name)
+}
+
+func TestValidateProblemOrder(t *testing.T) {
+	spec := model.JobSpec{Id: "plan-1", Tasks: []model.Task{{
+		Id:       "task-1",
+		Schedule: "0 * * * * *",
+		Matrix:   map[string][]string{"b": {}, "a": {}, "c": {}},
+		Activities: []model.Activity{{
+			Id:       "activity-1",
+			Provider: model.Provider{Name: "busy", Image: "ghcr.io/compliance-framework/busy", Tag: "1.0.0", Configuration: map[string]string{"z": "{{", "y": "{{", "x": "{{"}},
+		}},
+	}}}
+
+	paths := func() []string {
+		var validationErr *ValidationError
+		require.ErrorAs(t, validate(spec), &validationErr)
+		paths := make([]string, 0, len(validationErr.Problems))
+		for _, problem := range validationErr.Problems {
+			paths = append(paths, problem.Path)
+		}
+		return paths
+	}
+
+	expected := []string{
+		"tasks[0].matrix.a", "tasks[0].matrix.b", "tasks[0].matrix.c",
+		"tasks[0].activities[0].provider.configuration.x",
+		"tasks[0].activities[0].provider.configuration.y",
+		"tasks[0].activities[0].provider.configuration.z",
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, paths())
+	}
+}
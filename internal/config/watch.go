@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Watch polls the assessments directory until ctx is done, and reloads the plans when its files change, so
+// plans can be edited in place or synchronised by GitOps tools. A change is only picked up once the directory
+// has not changed for a whole interval, so files written in several steps are not loaded half way.
+func (cm *ConfigurationManager) Watch(ctx context.Context) {
+	interval := cm.config.WatchInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = defaultWatchInterval
+	}
+
+	// Starting from an empty snapshot reloads the directory once, which picks up changes made since the
+	// plans were loaded and publishes nothing if there were none.
+	var last map[string][32]byte
+	pending := false
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := snapshot(assessmentPath)
+			if err != nil {
+				log.Warnf("failed to read assessments directory: %s", err)
+				continue
+			}
+
+			if !equalSnapshots(last, current) {
+				last = current
+				pending = true
+				continue
+			}
+			if pending {
+				pending = false
+				cm.reload()
+			}
+		}
+	}
+}
+
+// reload loads the plans from the assessments directory and has them rescheduled if they changed.
+func (cm *ConfigurationManager) reload() {
+	cm.applyMu.Lock()
+	defer cm.applyMu.Unlock()
+
+	previous := cm.JobSpecs()
+	err := cm.loadJobSpecs(assessmentPath)
+	if err != nil {
+		log.Errorf("failed to load job specs: %s", err)
+		return
+	}
+
+	// Plans written by the configuration manager itself have already been loaded.
+	if sameJobSpecs(previous, cm.JobSpecs()) {
+		return
+	}
+	log.Info("Assessments directory changed, reloading job specs")
+
+	err = registry.EnsurePackages(cm.Packages())
+	if err != nil {
+		log.Errorf("Error downloading some of the plugins: %s", err)
+	}
+
+	pubsub.Publish(pubsub.Event{
+		Type: pubsub.ConfigurationUpdated,
+		Data: cm.JobSpecs(),
+	})
+}
+
+// snapshot returns the checksums of the plan files in dir by file name.
+func snapshot(dir string) (map[string][32]byte, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string][32]byte)
+	for _, file := range files {
+		fileExt := filepath.Ext(file.Name())
+		if fileExt != ".yaml" && fileExt != ".yml" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			// Removed since the directory was read.
+			continue
+		}
+		sums[file.Name()] = sha256.Sum256(data)
+	}
+	return sums, nil
+}
+
+func equalSnapshots(a, b map[string][32]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, sum := range a {
+		if other, ok := b[name]; !ok || other != sum {
+			return false
+		}
+	}
+	return true
+}
+
+func sameJobSpecs(a, b []model.JobSpec) bool {
+	dataA, errA := yaml.Marshal(a)
+	dataB, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && string(dataA) == string(dataB)
+}
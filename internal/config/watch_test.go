@@ -0,0 +1,56 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWatch(t *testing.T) {
+	isolate(t)
+	cm := &ConfigurationManager{config: Config{WatchInterval: 10 * time.Millisecond}}
+
+	updates := subscribe(t, pubsub.ConfigurationUpdated)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cm.Watch(ctx)
+
+	binary := filepath.Join(t.TempDir(), "plugin")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+	plan := model.JobSpec{Id: "plan-1", Title: "Plan", Tasks: []model.Task{{
+		Id:       "task-1",
+		Schedule: "*/10 * * * * *",
+		Activities: []model.Activity{{
+			Id:       "activity-1",
+			Provider: model.Provider{Name: "watch-test", Image: "file://" + binary, Tag: "1.0.0", Configuration: map[string]string{}},
+		}},
+	}}}
+	data, err := yaml.Marshal(plan)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(assessmentPath, "invalid.yaml"), []byte("id: plan-2\nunknown: true\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(assessmentPath, "plan-1.yaml"), data, 0644))
+
+	select {
+	case e := <-updates:
+		assert.Equal(t, []model.JobSpec{plan}, e.Data, "invalid plans are skipped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no configuration update after the assessments directory changed")
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(assessmentPath, "notes.txt"), []byte("not a plan"), 0644))
+	select {
+	case e := <-updates:
+		t.Fatalf("unexpected configuration update: %v", e.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -2,9 +2,12 @@ package event
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
 	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
 	"sync"
+	"time"
 )
 
 type chanHolder struct {
@@ -17,16 +20,106 @@ var (
 	mu    sync.Mutex
 )
 
-func Connect(server string) error {
+// Options configures how the runtime authenticates to the event bus and verifies its identity.
+type Options struct {
+	// Name identifies the connection on the server. The runtime sets it to its runtime id.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities trusted for the server. Setting it or a client
+	// certificate requires TLS.
+	CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+
+	// CertFile and KeyFile are the PEM client certificate and key presented for mutual TLS.
+	CertFile string `yaml:"certFile,omitempty" json:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty" json:"keyFile,omitempty"`
+
+	User     string `yaml:"user,omitempty" json:"user,omitempty"`
+	Password string `yaml:"password,omitempty" json:"-"`
+	Token    string `yaml:"token,omitempty" json:"-"`
+
+	// NKeyFile is a file holding an NKey user seed.
+	NKeyFile string `yaml:"nkeyFile,omitempty" json:"nkeyFile,omitempty"`
+
+	// CredentialsFile is a .creds file holding a user JWT and its NKey seed.
+	CredentialsFile string `yaml:"credentialsFile,omitempty" json:"credentialsFile,omitempty"`
+}
+
+// ConnectionStatus is published on pubsub.EventBusDisconnected, pubsub.EventBusReconnected and
+// pubsub.EventBusClosed as the connection to the event bus changes.
+type ConnectionStatus struct {
+	Server string
+	Error  error
+}
+
+func (opts Options) natsOptions() ([]nats.Option, error) {
+	natsOpts := []nats.Option{
+		nats.ReconnectBufSize(5 * 1024 * 1024),
+		// The runtime cannot work without the event bus, so it keeps trying to get it back.
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(c *nats.Conn, err error) {
+			log.WithField("server", c.ConnectedUrlRedacted()).Warnf("Disconnected from event bus: %v", err)
+			pubsub.Publish(pubsub.Event{Type: pubsub.EventBusDisconnected, Data: ConnectionStatus{Server: c.ConnectedUrlRedacted(), Error: err}})
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.WithField("server", c.ConnectedUrlRedacted()).Info("Reconnected to event bus")
+			pubsub.Publish(pubsub.Event{Type: pubsub.EventBusReconnected, Data: ConnectionStatus{Server: c.ConnectedUrlRedacted()}})
+		}),
+		nats.ClosedHandler(func(c *nats.Conn) {
+			log.Info("Event bus connection closed")
+			pubsub.Publish(pubsub.Event{Type: pubsub.EventBusClosed, Data: ConnectionStatus{Error: c.LastError()}})
+		}),
+		nats.ErrorHandler(func(c *nats.Conn, sub *nats.Subscription, err error) {
+			fields := log.Fields{}
+			if sub != nil {
+				fields["subject"] = sub.Subject
+			}
+			log.WithFields(fields).Errorf("Event bus error: %v", err)
+		}),
+	}
+
+	if opts.Name != "" {
+		natsOpts = append(natsOpts, nats.Name(opts.Name))
+	}
+	if opts.CAFile != "" {
+		natsOpts = append(natsOpts, nats.RootCAs(opts.CAFile))
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		natsOpts = append(natsOpts, nats.ClientCert(opts.CertFile, opts.KeyFile))
+	}
+	if opts.User != "" {
+		natsOpts = append(natsOpts, nats.UserInfo(opts.User, opts.Password))
+	}
+	if opts.Token != "" {
+		natsOpts = append(natsOpts, nats.Token(opts.Token))
+	}
+	if opts.NKeyFile != "" {
+		nkey, err := nats.NkeyOptionFromSeed(opts.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load nkey seed: %w", err)
+		}
+		natsOpts = append(natsOpts, nkey)
+	}
+	if opts.CredentialsFile != "" {
+		natsOpts = append(natsOpts, nats.UserCredentials(opts.CredentialsFile))
+	}
+
+	return natsOpts, nil
+}
+
+func Connect(server string, opts Options) error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if conn != nil && len(subCh) > 0 {
+	if conn != nil && !conn.IsClosed() {
 		return nil
 	}
 
-	var err error
-	conn, err = nats.Connect(server, nats.ReconnectBufSize(5*1024*1024))
+	natsOpts, err := opts.natsOptions()
+	if err != nil {
+		return err
+	}
+
+	conn, err = nats.Connect(server, natsOpts...)
 	if err != nil {
 		return err
 	}
@@ -54,6 +147,37 @@ func Subscribe[T any](topic string) (chan T, error) {
 	return ch, nil
 }
 
+// Request is a message received with SubscribeRequests. Reply is the subject the sender waits for an answer on,
+// empty when the message was published without expecting one. Err is set instead of Data when the message
+// could not be decoded, so the sender can still be answered.
+type Request[T any] struct {
+	Data  T
+	Reply string
+	Err   error
+}
+
+// SubscribeRequests is like Subscribe, but keeps the reply subject of every message so it can be answered.
+func SubscribeRequests[T any](topic string) (chan Request[T], error) {
+	ch := make(chan Request[T])
+	_, err := conn.Subscribe(topic, func(m *nats.Msg) {
+		var msg T
+		err := json.Unmarshal(m.Data, &msg)
+		if err != nil {
+			log.WithField("subject", m.Subject).Errorf("Error unmarshalling data: %v", err)
+			ch <- Request[T]{Reply: m.Reply, Err: fmt.Errorf("failed to decode message: %w", err)}
+			return
+		}
+		ch <- Request[T]{Data: msg, Reply: m.Reply}
+	})
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	subCh = append(subCh, chanHolder{Ch: ch})
+	mu.Unlock()
+	return ch, nil
+}
+
 func Publish[T any](msg T, topic string) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -62,6 +186,11 @@ func Publish[T any](msg T, topic string) error {
 	return conn.Publish(topic, data)
 }
 
+// Flush waits until the server has received everything published so far, or the timeout has passed.
+func Flush(timeout time.Duration) error {
+	return conn.FlushTimeout(timeout)
+}
+
 func Close() {
 	conn.Close()
 	for _, holder := range subCh {
@@ -15,7 +15,7 @@ func TestBus(t *testing.T) {
 	s := natsserver.RunServer(&natsserver.DefaultTestOptions)
 	defer s.Shutdown()
 
-	err := Connect(nats.DefaultURL)
+	err := Connect(nats.DefaultURL, Options{})
 	assert.NoError(t, err)
 
 	topic := "test"
@@ -33,3 +33,48 @@ func TestBus(t *testing.T) {
 
 	Close()
 }
+
+func TestSubscribeRequests(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+
+	err := Connect(s.ClientURL(), Options{})
+	assert.NoError(t, err)
+	defer Close()
+
+	ch, err := SubscribeRequests[Message]("test")
+	assert.NoError(t, err)
+
+	assert.NoError(t, conn.PublishRequest("test", "reply", []byte(`{"text": "Hello"}`)))
+	req := <-ch
+	assert.NoError(t, req.Err)
+	assert.Equal(t, "Hello", req.Data.Text)
+	assert.Equal(t, "reply", req.Reply)
+
+	assert.NoError(t, conn.PublishRequest("test", "reply", []byte(`not json`)))
+	req = <-ch
+	assert.Error(t, req.Err, "messages that cannot be decoded are still delivered to be answered")
+	assert.Equal(t, "reply", req.Reply)
+}
+
+func TestConnectWithToken(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.Authorization = "s3cret"
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+
+	assert.Error(t, Connect(s.ClientURL(), Options{Token: "wrong"}))
+
+	err := Connect(s.ClientURL(), Options{Token: "s3cret", Name: "runtime-1"})
+	assert.NoError(t, err)
+	defer Close()
+
+	connz, err := s.Connz(nil)
+	assert.NoError(t, err)
+	if assert.Len(t, connz.Conns, 1) {
+		assert.Equal(t, "runtime-1", connz.Conns[0].Name)
+	}
+}
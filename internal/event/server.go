@@ -0,0 +1,154 @@
+package event
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerOptions configures the NATS server embedded in the runtime, so a single runtime works without
+// an external event bus, e.g. on an edge site or in local development.
+type ServerOptions struct {
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+
+	// Name identifies the server in the cluster it is connected to. The runtime sets it to its runtime id.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+
+	// Host and Port are where the server accepts clients. They default to 127.0.0.1 and 4222,
+	// a port of -1 picks a random one.
+	Host string `yaml:"host,omitempty" json:"host,omitempty"`
+	Port int    `yaml:"port,omitempty" json:"port,omitempty"`
+
+	// JetStream enables persistent streams, stored in StoreDir.
+	JetStream bool   `yaml:"jetStream,omitempty" json:"jetStream,omitempty"`
+	StoreDir  string `yaml:"storeDir,omitempty" json:"storeDir,omitempty"`
+
+	// LeafNode connects the server to a central cluster, which then exchanges messages with the runtime
+	// as if it was connected to the cluster itself.
+	LeafNode LeafNodeOptions `yaml:"leafNode,omitempty" json:"leafNode,omitempty"`
+}
+
+// LeafNodeOptions configures the leafnode connection of the embedded server to a central cluster.
+type LeafNodeOptions struct {
+	// URL is the leafnode URL of the cluster, e.g. nats-leaf://nats.example.com:7422. No leafnode connection
+	// is made when it is empty.
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+
+	// CredentialsFile is a .creds file holding the user JWT and NKey seed the connection authenticates with.
+	CredentialsFile string `yaml:"credentialsFile,omitempty" json:"credentialsFile,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities trusted for the cluster. Setting it requires TLS.
+	CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+}
+
+const (
+	defaultServerHost = "127.0.0.1"
+	defaultServerPort = 4222
+	// serverStartTimeout bounds how long the embedded server may take to accept clients.
+	serverStartTimeout = 10 * time.Second
+)
+
+func (opts ServerOptions) serverOptions() (*server.Options, error) {
+	serverOpts := &server.Options{
+		ServerName: opts.Name,
+		Host:       opts.Host,
+		Port:       opts.Port,
+		JetStream:  opts.JetStream,
+		StoreDir:   opts.StoreDir,
+		// Signals are handled by the runtime.
+		NoSigs: true,
+	}
+	if serverOpts.Host == "" {
+		serverOpts.Host = defaultServerHost
+	}
+	if serverOpts.Port == 0 {
+		serverOpts.Port = defaultServerPort
+	}
+
+	if opts.LeafNode.URL != "" {
+		leafURL, err := url.Parse(opts.LeafNode.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid leafnode URL %q: %w", opts.LeafNode.URL, err)
+		}
+		remote := &server.RemoteLeafOpts{
+			URLs:        []*url.URL{leafURL},
+			Credentials: opts.LeafNode.CredentialsFile,
+		}
+		if opts.LeafNode.CAFile != "" {
+			pem, err := os.ReadFile(opts.LeafNode.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read leafnode CA bundle: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in leafnode CA bundle %s", opts.LeafNode.CAFile)
+			}
+			remote.TLS = true
+			remote.TLSConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		}
+		serverOpts.LeafNode.Remotes = []*server.RemoteLeafOpts{remote}
+	}
+
+	return serverOpts, nil
+}
+
+// StartServer starts the embedded NATS server and waits until it accepts clients, whose URL is its ClientURL.
+// The caller shuts it down once the runtime stops.
+func StartServer(opts ServerOptions) (*server.Server, error) {
+	serverOpts, err := opts.serverOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := server.NewServer(serverOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedded event bus: %w", err)
+	}
+	srv.SetLoggerV2(serverLogger{}, false, false, false)
+
+	go srv.Start()
+	if !srv.ReadyForConnections(serverStartTimeout) {
+		srv.Shutdown()
+		return nil, fmt.Errorf("embedded event bus did not start within %s", serverStartTimeout)
+	}
+
+	log.WithFields(log.Fields{
+		"url":       srv.ClientURL(),
+		"jetStream": srv.JetStreamEnabled(),
+		"leafNode":  opts.LeafNode.URL,
+	}).Info("Started embedded event bus")
+	return srv, nil
+}
+
+// serverLogger logs the messages of the embedded server with the rest of the runtime.
+type serverLogger struct{}
+
+func (serverLogger) Noticef(format string, v ...interface{}) {
+	log.WithField("component", "event-bus").Infof(format, v...)
+}
+
+func (serverLogger) Warnf(format string, v ...interface{}) {
+	log.WithField("component", "event-bus").Warnf(format, v...)
+}
+
+func (serverLogger) Fatalf(format string, v ...interface{}) {
+	log.WithField("component", "event-bus").Fatalf(format, v...)
+}
+
+func (serverLogger) Errorf(format string, v ...interface{}) {
+	log.WithField("component", "event-bus").Errorf(format, v...)
+}
+
+func (serverLogger) Debugf(format string, v ...interface{}) {
+	log.WithField("component", "event-bus").Debugf(format, v...)
+}
+
+func (serverLogger) Tracef(format string, v ...interface{}) {
+	log.WithField("component", "event-bus").Tracef(format, v...)
+}
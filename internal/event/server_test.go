@@ -0,0 +1,59 @@
+package event
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartServer(t *testing.T) {
+	hubOpts := natsserver.DefaultTestOptions
+	hubOpts.Port = -1
+	hubOpts.LeafNode.Host = "127.0.0.1"
+	hubOpts.LeafNode.Port = -1
+	hub := natsserver.RunServer(&hubOpts)
+	defer hub.Shutdown()
+
+	srv, err := StartServer(ServerOptions{
+		Name:      "edge-runtime",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		LeafNode:  LeafNodeOptions{URL: fmt.Sprintf("nats-leaf://127.0.0.1:%d", hubOpts.LeafNode.Port)},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+	assert.True(t, srv.JetStreamEnabled())
+
+	require.Eventually(t, func() bool {
+		return srv.NumLeafNodes() == 1
+	}, 5*time.Second, 10*time.Millisecond, "the embedded server connects to the hub")
+
+	// Messages published at the edge reach subscribers of the central cluster.
+	central, err := nats.Connect(hub.ClientURL())
+	require.NoError(t, err)
+	defer central.Close()
+	sub, err := central.SubscribeSync("runtime.edge-runtime.heartbeat")
+	require.NoError(t, err)
+	require.NoError(t, central.Flush())
+
+	edge, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	defer edge.Close()
+
+	require.Eventually(t, func() bool {
+		if err := edge.Publish("runtime.edge-runtime.heartbeat", []byte("alive")); err != nil {
+			return false
+		}
+		msg, err := sub.NextMsg(100 * time.Millisecond)
+		return err == nil && string(msg.Data) == "alive"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = StartServer(ServerOptions{Port: -1, LeafNode: LeafNodeOptions{URL: "nats-leaf://127.0.0.1:1", CAFile: "missing.pem"}})
+	assert.Error(t, err)
+}
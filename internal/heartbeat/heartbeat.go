@@ -0,0 +1,199 @@
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/event"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultInterval = 30 * time.Second
+
+// deregisterTimeout bounds how long shutting down waits for the deregistration to reach the event bus.
+const deregisterTimeout = 5 * time.Second
+
+// Registration is published on runtime.<runtimeId>.register when the runtime starts.
+type Registration struct {
+	RuntimeId string            `json:"runtimeId"`
+	Version   string            `json:"version"`
+	Labels    map[string]string `json:"labels"`
+	Plugins   []Plugin          `json:"plugins"`
+	Capacity  Capacity          `json:"capacity"`
+	// Interval is how often heartbeats are sent, so the control plane can tell when one is overdue.
+	Interval  time.Duration `json:"interval"`
+	StartedAt time.Time     `json:"startedAt"`
+}
+
+type Plugin struct {
+	Name   string `json:"name"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+type Capacity struct {
+	CPUs       int `json:"cpus"`
+	GoMaxProcs int `json:"gomaxprocs"`
+}
+
+// Heartbeat is published on runtime.<runtimeId>.heartbeat every interval while the runtime is running.
+type Heartbeat struct {
+	RuntimeId string        `json:"runtimeId"`
+	Timestamp time.Time     `json:"timestamp"`
+	Interval  time.Duration `json:"interval"`
+	Load      Load          `json:"load"`
+}
+
+type Load struct {
+	RunningPlans []string `json:"runningPlans"`
+	Goroutines   int      `json:"goroutines"`
+	MemoryBytes  uint64   `json:"memoryBytes"`
+}
+
+// Deregistration is published on runtime.<runtimeId>.deregister when the runtime shuts down.
+type Deregistration struct {
+	RuntimeId string    `json:"runtimeId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Reporter tells the control plane that the runtime exists and is alive.
+type Reporter struct {
+	RuntimeId string
+	Version   string
+	// Interval between heartbeats. Zero uses DefaultInterval.
+	Interval time.Duration
+	// Labels returns the labels currently advertised by the runtime.
+	Labels func() map[string]string
+	// Running returns the ids of the plans currently running.
+	Running func() []string
+
+	startedAt time.Time
+}
+
+func (r *Reporter) topic(name string) string {
+	return fmt.Sprintf("runtime.%s.%s", r.RuntimeId, name)
+}
+
+func (r *Reporter) interval() time.Duration {
+	if r.Interval > 0 {
+		return r.Interval
+	}
+	return DefaultInterval
+}
+
+// Register announces the runtime with its version, labels, installed plugins and capacity.
+func (r *Reporter) Register() error {
+	if r.startedAt.IsZero() {
+		r.startedAt = time.Now().UTC()
+	}
+
+	registration := Registration{
+		RuntimeId: r.RuntimeId,
+		Version:   r.Version,
+		Labels:    map[string]string{},
+		Plugins:   []Plugin{},
+		Capacity: Capacity{
+			CPUs:       runtime.NumCPU(),
+			GoMaxProcs: runtime.GOMAXPROCS(0),
+		},
+		Interval:  r.interval(),
+		StartedAt: r.startedAt,
+	}
+	if r.Labels != nil {
+		registration.Labels = r.Labels()
+	}
+
+	installed, err := registry.Inventory()
+	if err != nil {
+		log.Warnf("Failed to list installed plugins for registration: %s", err)
+	}
+	for _, plugin := range installed {
+		registration.Plugins = append(registration.Plugins, Plugin{
+			Name:   plugin.Name,
+			Tag:    plugin.Tag,
+			Digest: plugin.Digest,
+		})
+	}
+
+	err = event.Publish(registration, r.topic("register"))
+	if err != nil {
+		return fmt.Errorf("failed to publish registration: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"runtimeId": r.RuntimeId,
+		"version":   r.Version,
+	}).Info("Registered runtime")
+	return nil
+}
+
+// Beat publishes a single heartbeat with the current load.
+func (r *Reporter) Beat() error {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	heartbeat := Heartbeat{
+		RuntimeId: r.RuntimeId,
+		Timestamp: time.Now().UTC(),
+		Interval:  r.interval(),
+		Load: Load{
+			RunningPlans: []string{},
+			Goroutines:   runtime.NumGoroutine(),
+			MemoryBytes:  memory.Sys,
+		},
+	}
+	if r.Running != nil {
+		heartbeat.Load.RunningPlans = r.Running()
+	}
+
+	return event.Publish(heartbeat, r.topic("heartbeat"))
+}
+
+// Deregister tells the control plane that the runtime is shutting down on purpose.
+func (r *Reporter) Deregister() error {
+	err := event.Publish(Deregistration{
+		RuntimeId: r.RuntimeId,
+		Timestamp: time.Now().UTC(),
+	}, r.topic("deregister"))
+	if err != nil {
+		return fmt.Errorf("failed to publish deregistration: %w", err)
+	}
+	// The runtime exits right after, so the deregistration must not be left in the connection's buffer.
+	err = event.Flush(deregisterTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to flush deregistration: %w", err)
+	}
+
+	log.WithField("runtimeId", r.RuntimeId).Info("Deregistered runtime")
+	return nil
+}
+
+// Run registers the runtime, sends heartbeats until ctx is done and then deregisters it.
+func (r *Reporter) Run(ctx context.Context) {
+	err := r.Register()
+	if err != nil {
+		log.Errorf("Failed to register runtime: %s", err)
+	}
+
+	ticker := time.NewTicker(r.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := r.Deregister()
+			if err != nil {
+				log.Errorf("Failed to deregister runtime: %s", err)
+			}
+			return
+		case <-ticker.C:
+			err := r.Beat()
+			if err != nil {
+				log.Warnf("Failed to publish heartbeat: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package heartbeat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/event"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReporter(t *testing.T) {
+	s := natsserver.RunServer(&natsserver.DefaultTestOptions)
+	defer s.Shutdown()
+
+	require.NoError(t, event.Connect(nats.DefaultURL, event.Options{}))
+
+	registrations, err := event.Subscribe[Registration]("runtime.test-runtime.register")
+	require.NoError(t, err)
+	heartbeats, err := event.Subscribe[Heartbeat]("runtime.test-runtime.heartbeat")
+	require.NoError(t, err)
+	deregistrations, err := event.Subscribe[Deregistration]("runtime.test-runtime.deregister")
+	require.NoError(t, err)
+
+	reporter := &Reporter{
+		RuntimeId: "test-runtime",
+		Version:   "1.2.3",
+		Interval:  10 * time.Millisecond,
+		Labels: func() map[string]string {
+			return map[string]string{"region": "eu-west-1"}
+		},
+		Running: func() []string {
+			return []string{"plan-1"}
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reporter.Run(ctx)
+		close(done)
+	}()
+
+	registration := <-registrations
+	assert.Equal(t, "1.2.3", registration.Version)
+	assert.Equal(t, "eu-west-1", registration.Labels["region"])
+	assert.Positive(t, registration.Capacity.CPUs)
+
+	heartbeat := <-heartbeats
+	assert.Equal(t, "test-runtime", heartbeat.RuntimeId)
+	assert.Equal(t, []string{"plan-1"}, heartbeat.Load.RunningPlans)
+
+	cancel()
+	<-done
+	deregistration := <-deregistrations
+	assert.Equal(t, "test-runtime", deregistration.RuntimeId)
+}
@@ -10,6 +10,9 @@ type Result struct {
 	ControlId    string                   `json:"controlId"`
 	TaskId       string                   `json:"taskId"`
 	ActivityId   string                   `json:"activityId"`
+	Matrix       map[string]string        `json:"matrix,omitempty"`
+	PluginDigest string                   `json:"pluginDigest"`
+	PlanRevision string                   `json:"planRevision"`
 	Error        error                    `json:"error"`
 	Subject      *provider.Subject        `json:"subjects"`
 	Observations []*provider.Observation  `json:"observations"`
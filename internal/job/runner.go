@@ -5,27 +5,40 @@ import (
 	"errors"
 	"fmt"
 	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
+	"github.com/compliance-framework/assessment-runtime/internal/secret"
 	"github.com/compliance-framework/assessment-runtime/provider"
+	"github.com/hashicorp/go-hclog"
 	goplugin "github.com/hashicorp/go-plugin"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"sync"
 )
 
 type Runner struct {
-	spec    model.JobSpec
-	clients map[string]*goplugin.Client
+	spec     model.JobSpec
+	revision string
+	clients  map[string]*goplugin.Client
+	digests  map[string]string
 }
 
-func NewRunner(spec model.JobSpec) (*Runner, error) {
+// NewRunner loads the plugins of the spec, with its templates rendered and its matrices expanded for the
+// runtime with the labels.
+func NewRunner(spec model.JobSpec, labels map[string]string) (*Runner, error) {
+	expanded, err := spec.Expand(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand job spec: %w", err)
+	}
+
 	a := &Runner{
-		spec:    spec,
-		clients: make(map[string]*goplugin.Client),
+		spec:     expanded,
+		revision: spec.Revision(),
+		clients:  make(map[string]*goplugin.Client),
+		digests:  make(map[string]string),
 	}
 
-	err := a.loadProviders()
+	err = a.loadProviders()
 	if err != nil {
 		return nil, err
 	}
@@ -41,11 +54,6 @@ func (r *Runner) loadProviders() error {
 		}
 	}
 
-	ex, err := os.Executable()
-	if err != nil {
-		return err
-	}
-
 	for pkg, plugins := range pluginMap {
 		log.WithField("package", pkg).Info("Loading package")
 
@@ -54,15 +62,30 @@ func (r *Runner) loadProviders() error {
 			log.WithField("plugin", pluginConfig.Name).Info("Loading plugin")
 			pluginMap[pluginConfig.Name] = &provider.GrpcPlugin{}
 		}
-		pluginsPath := filepath.Join(filepath.Dir(ex), "./plugins")
-		packagePath := fmt.Sprintf("%s/%s/%s/%s", pluginsPath, pkg, plugins[0].Tag, "plugin")
+		packagePath, err := registry.ExecutablePath(pkg, plugins[0].Tag)
+		if err != nil {
+			return err
+		}
 
 		log.WithFields(log.Fields{
 			"package":     pkg,
-			"pluginsPath": pluginsPath,
 			"packagePath": packagePath,
 		}).Info("Loading plugin package")
 
+		// Plugins are moved into place only once fully installed, so a missing executable means it is not installed yet.
+		if _, err := os.Stat(packagePath); err != nil {
+			return fmt.Errorf("plugin %s:%s is not installed: %w", pkg, plugins[0].Tag, err)
+		}
+		if err := registry.MarkUsed(pkg, plugins[0].Tag); err != nil {
+			log.WithField("package", pkg).Warnf("Failed to record plugin usage: %s", err)
+		}
+
+		// The digest ties the results to the exact plugin build that produced them.
+		digest, err := registry.InstalledDigest(pkg, plugins[0].Tag)
+		if err != nil {
+			log.WithField("package", pkg).Warnf("Failed to read plugin digest: %s", err)
+		}
+
 		cmd := exec.Command(packagePath)
 		cmd.Env = os.Environ()
 
@@ -71,10 +94,17 @@ func (r *Runner) loadProviders() error {
 			Plugins:          pluginMap,
 			Cmd:              cmd,
 			AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+			// Plugins receive the resolved secrets, so they are masked in whatever the plugins log.
+			Logger: hclog.New(&hclog.LoggerOptions{
+				Name:   "plugin",
+				Output: secret.Writer(os.Stderr),
+				Level:  hclog.Trace,
+			}),
 		})
 
 		for _, pluginConfig := range plugins {
 			r.clients[pluginConfig.Name] = client
+			r.digests[pluginConfig.Name] = digest
 		}
 	}
 
@@ -110,68 +140,58 @@ func (r *Runner) provider(name string) (provider.Provider, error) {
 	return raw.(provider.Provider), nil
 }
 
-func (r *Runner) evaluate(activityId string) (*provider.EvaluateResult, error) {
-	for _, task := range r.spec.Tasks {
-		for _, activity := range task.Activities {
-			if activity.Id == activityId {
-
-				// Get the provider
-				p, err := r.provider(activity.Provider.Name)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"assessment-plan-id": r.spec.PlanId,
-						"task":               task.Id,
-						"activity":           activity.Id,
-						"error":              err,
-					}).Error("failed to get provider")
-					return nil, err
-				}
-
-				// Convert the expressions to the provider's format
-				expressions := make([]*provider.Expression, 0)
-				for _, expression := range activity.Selector.Expressions {
-					expressions = append(expressions, &provider.Expression{
-						Key:      expression.Key,
-						Operator: expression.Operator,
-						Values:   expression.Values,
-					})
-				}
-
-				// TODO: Add missing information to the input: ComponentId, ControlId, etc.
-				input := &provider.EvaluateInput{
-					Plan: &provider.Plan{
-						Id:          r.spec.PlanId,
-						ComponentId: r.spec.ComponentId,
-						ControlId:   r.spec.ControlId,
-						TaskId:      task.Id,
-						ActivityId:  activity.Id,
-					},
-					Selector: &provider.Selector{
-						Query:       activity.Selector.Query,
-						Labels:      activity.Selector.Labels,
-						Expressions: expressions,
-						Ids:         activity.Selector.Ids,
-					},
-					Configuration: activity.Provider.Configuration,
-				}
-				result, err := p.Evaluate(input)
-
-				if err != nil {
-					log.WithFields(log.Fields{
-						"provider": activity.Provider.Name,
-						"error":    err,
-					}).Error("failed to evaluate selector")
-					return nil, err
-				}
-
-				return result, nil
-			}
-		}
+// evaluate finds the subjects of the activity, using the configuration with its secrets resolved.
+func (r *Runner) evaluate(task model.Task, activity model.Activity, configuration map[string]string) (*provider.EvaluateResult, error) {
+	// Get the provider
+	p, err := r.provider(activity.Provider.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"assessment-plan-id": r.spec.PlanId,
+			"task":               task.Id,
+			"activity":           activity.Id,
+			"error":              err,
+		}).Error("failed to get provider")
+		return nil, err
 	}
 
-	err := fmt.Errorf("activity %s not found", activityId)
-	log.WithField("activity", activityId).Error(err)
-	return nil, err
+	// Convert the expressions to the provider's format
+	expressions := make([]*provider.Expression, 0)
+	for _, expression := range activity.Selector.Expressions {
+		expressions = append(expressions, &provider.Expression{
+			Key:      expression.Key,
+			Operator: expression.Operator,
+			Values:   expression.Values,
+		})
+	}
+
+	// TODO: Add missing information to the input: ComponentId, ControlId, etc.
+	input := &provider.EvaluateInput{
+		Plan: &provider.Plan{
+			Id:          r.spec.PlanId,
+			ComponentId: r.spec.ComponentId,
+			ControlId:   r.spec.ControlId,
+			TaskId:      task.Id,
+			ActivityId:  activity.Id,
+		},
+		Selector: &provider.Selector{
+			Query:       activity.Selector.Query,
+			Labels:      activity.Selector.Labels,
+			Expressions: expressions,
+			Ids:         activity.Selector.Ids,
+		},
+		Configuration: configuration,
+	}
+	result, err := p.Evaluate(input)
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"provider": activity.Provider.Name,
+			"error":    err,
+		}).Error("failed to evaluate selector")
+		return nil, err
+	}
+
+	return result, nil
 }
 
 func (r *Runner) execute(name string, input *provider.ExecuteInput) (*provider.ExecuteResult, error) {
@@ -195,7 +215,7 @@ func (r *Runner) execute(name string, input *provider.ExecuteInput) (*provider.E
 
 	log.WithFields(log.Fields{
 		"plugin": name,
-		"result": result,
+		"result": secret.MaskedCopy(result),
 	}).Info("provider executed successfully")
 
 	return result, nil
@@ -211,8 +231,20 @@ func (r *Runner) Run(ctx context.Context) []Result {
 
 		for _, activity := range task.Activities {
 
+			// Secrets are only resolved for the run, so they are never part of the spec that is stored.
+			configuration, err := secret.ResolveMap(activity.Provider.Configuration)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"assessment-plan-id": r.spec.PlanId,
+					"task":               task.Id,
+					"activity":           activity.Id,
+					"error":              err,
+				}).Error("failed to resolve provider configuration")
+				continue
+			}
+
 			// Get evaluate for the activity
-			evaluateResult, err := r.evaluate(activity.Id)
+			evaluateResult, err := r.evaluate(task, activity, configuration)
 			if err != nil {
 				log.WithFields(log.Fields{
 					"assessment-plan-id": r.spec.PlanId,
@@ -248,7 +280,10 @@ func (r *Runner) Run(ctx context.Context) []Result {
 						ControlId:    r.spec.ControlId,
 						TaskId:       task.Id,
 						ActivityId:   activity.Id,
-						Subject:      subject,
+						Matrix:       activity.Matrix,
+						PluginDigest: r.digests[pluginName],
+						PlanRevision: r.revision,
+						Subject:      secret.MaskedCopy(subject),
 					}
 
 					select {
@@ -268,13 +303,15 @@ func (r *Runner) Run(ctx context.Context) []Result {
 								},
 								Subject:       subject,
 								Props:         evaluateResult.Props,
-								Configuration: pluginConfig.Configuration,
+								Configuration: configuration,
 							}
 							output, err := r.execute(pluginName, &input)
 							if err != nil {
 								result.Error = errors.New("execution cancelled")
 								log.WithField("plugin", pluginName).Error(err)
 							} else {
+								// Results leave the runtime, so secrets are masked in them.
+								output = secret.MaskedCopy(output)
 								result.Observations = output.Observations
 								result.Findings = output.Findings
 								result.Risks = output.Risks
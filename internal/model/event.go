@@ -1,6 +1,52 @@
 package model
 
+import "time"
+
+// Types of PlanEvent, sent by the control plane as a plan goes through its lifecycle.
+const (
+	PlanActivated   = "activated"
+	PlanUpdated     = "updated"
+	PlanDeleted     = "delete"
+	PlanDeactivated = "deactivated"
+	PlanPaused      = "paused"
+	PlanResumed     = "resumed"
+	// PlanRolledBack restores the plan to the revision of the event.
+	PlanRolledBack = "rollback"
+)
+
+// PlanEvent carries the whole plan for activated and updated events. The other events only need its id.
 type PlanEvent struct {
 	Type string  `yaml:"type" json:"type"`
 	Data JobSpec `yaml:"data" json:"data"`
+	// Revision is the revision a rollback event restores.
+	Revision string `yaml:"revision,omitempty" json:"revision,omitempty"`
+}
+
+// PlanStatus is the outcome of applying a PlanEvent, reported back to the control plane in a PlanAck.
+type PlanStatus string
+
+const (
+	// PlanAccepted is reported as soon as the event is received, before it is applied.
+	PlanAccepted PlanStatus = "accepted"
+	// PlanInvalid means the event or its plan was rejected without changing anything.
+	PlanInvalid PlanStatus = "invalid"
+	// PlanSkipped means the plan is targeted at other runtimes.
+	PlanSkipped PlanStatus = "skipped"
+	// PlanDownloadFailed means the plan was scheduled, but some of its plugins could not be downloaded yet.
+	PlanDownloadFailed PlanStatus = "plugin-download-failed"
+	PlanScheduled      PlanStatus = "scheduled"
+	PlanPausedStatus   PlanStatus = "paused"
+	PlanRemoved        PlanStatus = "removed"
+	// PlanFailed means the event could not be applied, e.g. because the plan could not be written to disk.
+	PlanFailed PlanStatus = "failed"
+)
+
+// PlanAck reports how a PlanEvent was applied by a runtime.
+type PlanAck struct {
+	RuntimeId string     `json:"runtimeId"`
+	PlanId    string     `json:"planId"`
+	Event     string     `json:"event"`
+	Status    PlanStatus `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
 }
@@ -1,5 +1,12 @@
 package model
 
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
 // JobSpec is the model used to communicate with the runtime
 // It is used to publish a plan to the runtime. The runtime will then
 // use the information to execute the activities and publish the results back to the control-plane.
@@ -24,6 +31,13 @@ type JobSpec struct {
 	ComponentId string `json:"component-id" yaml:"component-id"`
 	ControlId   string `json:"control-id" yaml:"control-id"`
 	Tasks       []Task `json:"tasks" yaml:"tasks"`
+	// RuntimeSelector limits the plan to the runtimes whose labels match. Plans without one run on every runtime.
+	RuntimeSelector *RuntimeSelector `json:"runtime-selector,omitempty" yaml:"runtime-selector,omitempty"`
+	// Paused plans stay installed but are not scheduled until they are resumed.
+	Paused bool `json:"paused,omitempty" yaml:"paused,omitempty"`
+	// Parameters are named sets of values the templates of the activities can refer to,
+	// e.g. {{ .Parameters.production.subscription }}.
+	Parameters map[string]map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
 }
 
 type Task struct {
@@ -31,6 +45,9 @@ type Task struct {
 	Title      string     `json:"title" yaml:"title"`
 	Schedule   string     `json:"schedule" yaml:"schedule"`
 	Activities []Activity `json:"activities" yaml:"activities"`
+	// Matrix runs every activity of the task once for each combination of the values,
+	// which the templates of the activity refer to as e.g. {{ .Matrix.subscription }}.
+	Matrix map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"`
 }
 
 type Activity struct {
@@ -38,6 +55,8 @@ type Activity struct {
 	Title    string   `json:"title" yaml:"title"`
 	Selector Selector `json:"selector" yaml:"selector"`
 	Provider Provider `json:"provider" yaml:"provider"`
+	// Matrix holds the matrix values the activity was expanded with by JobSpec.Expand.
+	Matrix map[string]string `json:"-" yaml:"-"`
 }
 
 type Selector struct {
@@ -61,3 +80,14 @@ type Expression struct {
 	Operator string   `json:"operator" yaml:"operator"`
 	Values   []string `json:"values" yaml:"values"`
 }
+
+// Revision identifies the content of the spec, so results can be traced back to the plan version that
+// produced them. Pausing or resuming a plan does not change its revision.
+func (s JobSpec) Revision() string {
+	s.Paused = false
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:12]
+}
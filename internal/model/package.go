@@ -1,8 +1,46 @@
 package model
 
+import "strings"
+
 // Package represents a plugin package.
 type Package struct {
-	Name    string `yaml:"name" json:"name"`
-	Tag     string `yaml:"tag" json:"tag"`
-	Image   string `yaml:"image" json:"image"`
+	Name  string `yaml:"name" json:"name"`
+	Tag   string `yaml:"tag" json:"tag"`
+	Image string `yaml:"image" json:"image"`
+	// Digest pins the package to an exact image, set when the provider image is referenced as image@sha256:...
+	Digest string `yaml:"digest,omitempty" json:"digest,omitempty"`
+}
+
+// SplitDigest splits an image@sha256:... reference into the image and its digest.
+// The digest is empty when the image is not pinned.
+func SplitDigest(image string) (string, string) {
+	if idx := strings.LastIndex(image, "@"); idx != -1 && strings.Contains(image[idx+1:], ":") {
+		return image[:idx], image[idx+1:]
+	}
+	return image, ""
+}
+
+// Packages returns the plugin packages used by the activities of the spec, without duplicates.
+func (s JobSpec) Packages() []Package {
+	seen := make(map[string]bool)
+	packages := make([]Package, 0)
+
+	for _, task := range s.Tasks {
+		for _, activity := range task.Activities {
+			key := activity.Provider.Name + activity.Provider.Tag
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			image, digest := SplitDigest(activity.Provider.Image)
+			packages = append(packages, Package{
+				Name:   activity.Provider.Name,
+				Tag:    activity.Provider.Tag,
+				Image:  image,
+				Digest: digest,
+			})
+		}
+	}
+
+	return packages
 }
@@ -0,0 +1,64 @@
+package model
+
+// Operators supported by the expressions of a RuntimeSelector.
+const (
+	OperatorIn           = "In"
+	OperatorNotIn        = "NotIn"
+	OperatorExists       = "Exists"
+	OperatorDoesNotExist = "DoesNotExist"
+)
+
+// RuntimeSelector targets a plan at the runtimes whose labels match. A runtime matches when it has every label
+// in MatchLabels with the same value and satisfies all MatchExpressions. An empty selector matches every runtime.
+type RuntimeSelector struct {
+	MatchLabels      map[string]string `json:"matchLabels,omitempty" yaml:"matchLabels,omitempty"`
+	MatchExpressions []Expression      `json:"matchExpressions,omitempty" yaml:"matchExpressions,omitempty"`
+}
+
+// Matches reports whether a runtime with the labels is targeted by the selector.
+func (s *RuntimeSelector) Matches(labels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+
+	for key, value := range s.MatchLabels {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+
+	for _, expression := range s.MatchExpressions {
+		if !expression.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Matches reports whether the labels satisfy the expression. Unknown operators never match.
+func (e Expression) Matches(labels map[string]string) bool {
+	value, ok := labels[e.Key]
+
+	switch e.Operator {
+	case OperatorIn:
+		return ok && contains(e.Values, value)
+	case OperatorNotIn:
+		return !ok || !contains(e.Values, value)
+	case OperatorExists:
+		return ok
+	case OperatorDoesNotExist:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
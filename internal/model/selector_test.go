@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuntimeSelectorMatches(t *testing.T) {
+	labels := map[string]string{"region": "eu-west-1", "cloud": "aws"}
+
+	var empty *RuntimeSelector
+	assert.True(t, empty.Matches(labels))
+	assert.True(t, (&RuntimeSelector{}).Matches(nil))
+
+	assert.True(t, (&RuntimeSelector{MatchLabels: map[string]string{"cloud": "aws"}}).Matches(labels))
+	assert.False(t, (&RuntimeSelector{MatchLabels: map[string]string{"cloud": "azure"}}).Matches(labels))
+	assert.False(t, (&RuntimeSelector{MatchLabels: map[string]string{"zone": "a"}}).Matches(labels))
+
+	tests := []struct {
+		expression Expression
+		matches    bool
+	}{
+		{Expression{Key: "region", Operator: OperatorIn, Values: []string{"eu-west-1", "eu-central-1"}}, true},
+		{Expression{Key: "region", Operator: OperatorIn, Values: []string{"us-east-1"}}, false},
+		{Expression{Key: "region", Operator: OperatorNotIn, Values: []string{"us-east-1"}}, true},
+		{Expression{Key: "zone", Operator: OperatorNotIn, Values: []string{"a"}}, true},
+		{Expression{Key: "cloud", Operator: OperatorExists}, true},
+		{Expression{Key: "zone", Operator: OperatorExists}, false},
+		{Expression{Key: "zone", Operator: OperatorDoesNotExist}, true},
+		{Expression{Key: "cloud", Operator: "Like"}, false},
+	}
+	for _, test := range tests {
+		selector := &RuntimeSelector{MatchExpressions: []Expression{test.expression}}
+		assert.Equal(t, test.matches, selector.Matches(labels), "%+v", test.expression)
+	}
+}
@@ -0,0 +1,184 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// TemplateData holds the variables available to the templates in provider configurations and selectors.
+type TemplateData struct {
+	Id          string
+	PlanId      string
+	ComponentId string
+	ControlId   string
+	TaskId      string
+	ActivityId  string
+	// Labels are the labels of the runtime the plan runs on.
+	Labels     map[string]string
+	Parameters map[string]map[string]string
+	// Matrix holds the matrix values of the activity being expanded.
+	Matrix map[string]string
+}
+
+// CheckTemplate returns an error if s is not a valid template.
+func CheckTemplate(s string) error {
+	if !strings.Contains(s, "{{") {
+		return nil
+	}
+	_, err := template.New("").Option("missingkey=error").Parse(s)
+	return err
+}
+
+// Expand returns the spec with the activities of every task expanded across the task's matrix, and the
+// templates in their provider configurations and selectors rendered for the runtime with the labels.
+// Expanded activities keep their id, and hold the matrix values they were expanded with in Activity.Matrix.
+func (s JobSpec) Expand(labels map[string]string) (JobSpec, error) {
+	expanded := s
+	expanded.Tasks = make([]Task, 0, len(s.Tasks))
+
+	for _, task := range s.Tasks {
+		combinations := task.combinations()
+
+		activities := make([]Activity, 0, len(task.Activities)*len(combinations))
+		for _, activity := range task.Activities {
+			for _, matrix := range combinations {
+				data := TemplateData{
+					Id:          s.Id,
+					PlanId:      s.PlanId,
+					ComponentId: s.ComponentId,
+					ControlId:   s.ControlId,
+					TaskId:      task.Id,
+					ActivityId:  activity.Id,
+					Labels:      labels,
+					Parameters:  s.Parameters,
+					Matrix:      matrix,
+				}
+
+				a, err := activity.render(data)
+				if err != nil {
+					name := activity.Id
+					if len(matrix) > 0 {
+						name += "[" + formatMatrix(matrix) + "]"
+					}
+					return JobSpec{}, fmt.Errorf("activity %s: %w", name, err)
+				}
+				if len(matrix) > 0 {
+					a.Matrix = matrix
+				}
+				activities = append(activities, a)
+			}
+		}
+
+		task.Activities = activities
+		task.Matrix = nil
+		expanded.Tasks = append(expanded.Tasks, task)
+	}
+
+	return expanded, nil
+}
+
+// combinations returns every combination of the matrix values, in a stable order.
+// A task without a matrix has a single, empty combination.
+func (t Task) combinations() []map[string]string {
+	keys := make([]string, 0, len(t.Matrix))
+	for key := range t.Matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		next := make([]map[string]string, 0, len(combinations)*len(t.Matrix[key]))
+		for _, combination := range combinations {
+			for _, value := range t.Matrix[key] {
+				c := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					c[k] = v
+				}
+				c[key] = value
+				next = append(next, c)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+func formatMatrix(matrix map[string]string) string {
+	pairs := make([]string, 0, len(matrix))
+	for key, value := range matrix {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// render returns a copy of the activity with the templates in its provider configuration and selector rendered.
+func (a Activity) render(data TemplateData) (Activity, error) {
+	var err error
+	r := func(s string) string {
+		if err != nil {
+			return s
+		}
+		var rendered string
+		rendered, err = renderTemplate(s, data)
+		return rendered
+	}
+
+	if a.Provider.Configuration != nil {
+		configuration := make(map[string]string, len(a.Provider.Configuration))
+		for key, value := range a.Provider.Configuration {
+			configuration[key] = r(value)
+		}
+		a.Provider.Configuration = configuration
+	}
+
+	a.Selector.Title = r(a.Selector.Title)
+	a.Selector.Description = r(a.Selector.Description)
+	a.Selector.Query = r(a.Selector.Query)
+	if a.Selector.Labels != nil {
+		selectorLabels := make(map[string]string, len(a.Selector.Labels))
+		for key, value := range a.Selector.Labels {
+			selectorLabels[key] = r(value)
+		}
+		a.Selector.Labels = selectorLabels
+	}
+	if a.Selector.Expressions != nil {
+		expressions := make([]Expression, 0, len(a.Selector.Expressions))
+		for _, expression := range a.Selector.Expressions {
+			values := make([]string, 0, len(expression.Values))
+			for _, value := range expression.Values {
+				values = append(values, r(value))
+			}
+			expressions = append(expressions, Expression{Key: r(expression.Key), Operator: expression.Operator, Values: values})
+		}
+		a.Selector.Expressions = expressions
+	}
+	if a.Selector.Ids != nil {
+		ids := make([]string, 0, len(a.Selector.Ids))
+		for _, id := range a.Selector.Ids {
+			ids = append(ids, r(id))
+		}
+		a.Selector.Ids = ids
+	}
+
+	return a, err
+}
+
+func renderTemplate(s string, data TemplateData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
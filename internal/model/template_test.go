@@ -0,0 +1,70 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	spec := JobSpec{
+		Id:     "plan-1",
+		PlanId: "assessment-1",
+		Parameters: map[string]map[string]string{
+			"azure": {"tenant": "tenant-1"},
+		},
+		Tasks: []Task{{
+			Id: "task-1",
+			Matrix: map[string][]string{
+				"subscription": {"sub-a", "sub-b"},
+				"group":        {"rg-1"},
+			},
+			Activities: []Activity{{
+				Id: "activity-1",
+				Selector: Selector{
+					Query: "resourceGroup == '{{ .Matrix.group }}'",
+					Labels: map[string]string{
+						"region": "{{ .Labels.region }}",
+					},
+				},
+				Provider: Provider{
+					Name: "azure",
+					Configuration: map[string]string{
+						"subscription": "{{ .Matrix.subscription }}",
+						"tenant":       "{{ .Parameters.azure.tenant }}",
+						"plan":         "{{ .PlanId }}/{{ .ActivityId }}",
+						"secret":       "${env:AZURE_SECRET}",
+					},
+				},
+			}},
+		}},
+	}
+
+	expanded, err := spec.Expand(map[string]string{"region": "westeurope"})
+	require.NoError(t, err)
+	require.Len(t, expanded.Tasks[0].Activities, 2)
+	assert.Nil(t, expanded.Tasks[0].Matrix)
+
+	first := expanded.Tasks[0].Activities[0]
+	assert.Equal(t, "activity-1", first.Id)
+	assert.Equal(t, map[string]string{"group": "rg-1", "subscription": "sub-a"}, first.Matrix)
+	assert.Equal(t, "resourceGroup == 'rg-1'", first.Selector.Query)
+	assert.Equal(t, map[string]string{"region": "westeurope"}, first.Selector.Labels)
+	assert.Equal(t, map[string]string{
+		"subscription": "sub-a",
+		"tenant":       "tenant-1",
+		"plan":         "assessment-1/activity-1",
+		"secret":       "${env:AZURE_SECRET}",
+	}, first.Provider.Configuration)
+	assert.Equal(t, "sub-b", expanded.Tasks[0].Activities[1].Provider.Configuration["subscription"])
+
+	assert.Equal(t, "{{ .Matrix.subscription }}", spec.Tasks[0].Activities[0].Provider.Configuration["subscription"],
+		"the original spec is left untouched")
+
+	_, err = spec.Expand(nil)
+	assert.ErrorContains(t, err, "region", "missing variables are errors")
+
+	assert.NoError(t, CheckTemplate("{{ .Labels.region }}"))
+	assert.Error(t, CheckTemplate("{{ .Labels.region"))
+}
@@ -11,6 +11,11 @@ const (
 	AssessmentStarted
 	AssessmentCompleted
 	AssessmentFailed
+	PluginDownloadProgress
+	EventBusDisconnected
+	EventBusReconnected
+	EventBusClosed
+	PluginsChanged
 )
 
 type Event struct {
@@ -38,6 +43,20 @@ func Subscribe(topic EventType) (<-chan Event, error) {
 	return ch, nil
 }
 
+// Unsubscribe stops delivering the events of the topic on ch. The channel is not closed, as events published
+// before may still be delivered on it.
+func Unsubscribe(topic EventType, ch <-chan Event) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, sub := range subs[topic] {
+		if sub == ch {
+			subs[topic] = append(subs[topic][:i], subs[topic][i+1:]...)
+			return
+		}
+	}
+}
+
 func Publish(event Event) {
 	mu.RLock()
 	defer mu.RUnlock()
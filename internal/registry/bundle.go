@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	bundleManifestName = "bundle.json"
+	bundlePluginsDir   = "plugins"
+	bundleMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// bundleManifest lists the plugins contained in an offline bundle. It is the first entry of the bundle.
+type bundleManifest struct {
+	Plugins []Metadata `json:"plugins"`
+}
+
+// ExportBundle writes the plugins of the packages into a gzip compressed tarball at bundlePath, so they can be
+// imported on a runtime without access to the registries. Packages that are not installed yet are downloaded first.
+func ExportBundle(packages []model.Package, bundlePath string) error {
+	plugins := make([]InstalledPlugin, 0, len(packages))
+	for _, p := range packages {
+		pluginPath, err := PluginPath(p.Name, p.Tag)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(pluginPath); errors.Is(err, fs.ErrNotExist) {
+			err = downloadPackage(p)
+			if err != nil {
+				return fmt.Errorf("failed to download package %s:%s: %w", p.Name, p.Tag, err)
+			}
+		}
+
+		plugin, err := inspect(p.Name, p.Tag, pluginPath)
+		if err != nil {
+			return err
+		}
+		if plugin.Image == "" {
+			plugin.Image = p.Image
+		}
+		plugins = append(plugins, plugin)
+	}
+
+	tmpPath := bundlePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	err = writeBundle(file, plugins)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	return os.Rename(tmpPath, bundlePath)
+}
+
+func writeBundle(w io.Writer, plugins []InstalledPlugin) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	manifest := bundleManifest{Plugins: make([]Metadata, 0, len(plugins))}
+	for _, plugin := range plugins {
+		manifest.Plugins = append(manifest.Plugins, plugin.Metadata)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:     bundleManifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tarWriter.Write(data)
+	if err != nil {
+		return err
+	}
+
+	for _, plugin := range plugins {
+		prefix := path.Join(bundlePluginsDir, plugin.Name, plugin.Tag)
+		err = filepath.WalkDir(plugin.Path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Name() == lastUsedFileName {
+				return nil
+			}
+			return addToBundle(tarWriter, plugin.Path, file, prefix)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func addToBundle(tarWriter *tar.Writer, root string, file string, prefix string) error {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+
+	linkTarget := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		linkTarget, err = os.Readlink(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return err
+	}
+	header.Name = path.Join(prefix, filepath.ToSlash(rel))
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = io.Copy(tarWriter, in)
+	return err
+}
+
+// ImportBundle installs the plugins contained in a bundle created by ExportBundle and returns them.
+// The digests in the bundle cannot be checked against the images they were exported from, so the
+// imported plugins are marked as unverified.
+func ImportBundle(bundlePath string) ([]Metadata, error) {
+	manifest, err := readBundleManifest(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return nil, err
+	}
+	stagingRoot := filepath.Join(pluginsPath, stagingDirName)
+	err = os.MkdirAll(stagingRoot, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	unpacked, err := os.MkdirTemp(stagingRoot, "bundle-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(unpacked)
+	}()
+
+	// Unpack all the plugins in a single pass over the bundle, then install them one by one.
+	bundle := layer{Digest: bundlePath, MediaType: bundleMediaType, Path: bundlePath}
+	err = extractFolderFromLayers([]layer{bundle}, bundlePluginsDir, unpacked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack bundle: %w", err)
+	}
+
+	imported := make([]Metadata, 0, len(manifest.Plugins))
+	for _, m := range manifest.Plugins {
+		metadata := m
+		metadata.Unverified = true
+		p := model.Package{Name: metadata.Name, Tag: metadata.Tag, Image: metadata.Image}
+		err = install(p, func(destination string, workDir string) (string, error) {
+			return metadata.Digest, os.Rename(filepath.Join(unpacked, metadata.Name, metadata.Tag), destination)
+		}, true)
+		if err != nil {
+			return imported, fmt.Errorf("failed to import plugin %s:%s: %w", metadata.Name, metadata.Tag, err)
+		}
+
+		log.WithFields(log.Fields{
+			"package": metadata.Name,
+			"tag":     metadata.Tag,
+			"digest":  metadata.Digest,
+		}).Info("Imported package, its digest is unverified")
+		imported = append(imported, metadata)
+	}
+
+	return imported, nil
+}
+
+func readBundleManifest(bundlePath string) (*bundleManifest, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is not a plugin bundle: missing %s", bundlePath, bundleManifestName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if cleanArchivePath(header.Name) != bundleManifestName {
+			continue
+		}
+
+		var manifest bundleManifest
+		err = json.NewDecoder(tarReader).Decode(&manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", bundleManifestName, err)
+		}
+		return &manifest, nil
+	}
+}
@@ -8,25 +8,20 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"sync"
 	"context"
-	"archive/tar"
+	"crypto/sha256"
 	"strings"
-	"compress/gzip"
 	"encoding/json"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// DownloadPackages installs the packages and waits until all of them are installed.
 func DownloadPackages(packages []model.Package) error {
-	var wg sync.WaitGroup
-	var errorCh = make(chan error)
-
-	ex, err := os.Executable()
+	pluginsPath, err := PluginsDir()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	pluginsPath := filepath.Join(filepath.Dir(ex), "./plugins")
 	if _, err := os.Stat(pluginsPath); os.IsNotExist(err) {
 	 	err = os.MkdirAll(pluginsPath, 0755)
 		if err != nil {
@@ -35,35 +30,14 @@ func DownloadPackages(packages []model.Package) error {
 		}
 	}
 
-	for _, pkg := range packages {
-		wg.Add(1)
-		go func(p model.Package) {
-			defer wg.Done()
-			log.WithFields(log.Fields{
-				"package": p.Name,
-				"Tag":     p.Tag,
-				"image":   p.Image,
-			}).Info("Downloading package")
-			if err := downloadPackage(p); err != nil {
-				errorCh <- err
-			} else {
-				log.WithFields(log.Fields{
-					"package": p.Name,
-					"tag":     p.Tag,
-					"image":   p.Image,
-				}).Info("Downloaded package")
-			}
-		}(pkg)
+	installs := make([]*Install, 0, len(packages))
+	for _, p := range packages {
+		installs = append(installs, InstallPackage(p))
 	}
 
-	go func() {
-		wg.Wait()
-		close(errorCh)
-	}()
-
 	var errs []error
-	for err := range errorCh {
-		if err != nil {
+	for _, install := range installs {
+		if err := install.Wait(context.Background()); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -75,51 +49,50 @@ func DownloadPackages(packages []model.Package) error {
 	return nil
 }
 
-func downloadPackage(p model.Package) error {
-	ex, err := os.Executable()
-	if err != nil {
-		panic(err)
+// EnsurePackages downloads the packages that are not installed yet.
+func EnsurePackages(packages []model.Package) error {
+	missing := make([]model.Package, 0)
+	for _, p := range packages {
+		if !Installed(p) {
+			missing = append(missing, p)
+		}
 	}
 
-	currentPath          := filepath.Dir(ex)
-	pluginsPath          := currentPath + "/plugins"
-	pluginName           := p.Name
-	pluginExecutableName := "plugin"
-	pluginTag            := p.Tag
-	imageSpec            := p.Image
+	if len(missing) == 0 {
+		return nil
+	}
+	return DownloadPackages(missing)
+}
 
-	pluginPath := filepath.Join(pluginsPath, fmt.Sprintf("%s/%s", pluginName, pluginTag))
-	if _, err := os.Stat(pluginPath); os.IsNotExist(err) {
-		err = os.MkdirAll(pluginPath, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create directory: %v" , err)
-		}
+func downloadPackage(p model.Package) error {
+	fetch, err := sourceFor(p)
+	if err != nil {
+		return err
 	}
+	return install(p, fetch, false)
+}
 
-	if !strings.HasPrefix(imageSpec, "http") {
-		imageSpec = "https://" + imageSpec
-	} else {
-		imageSpec = imageSpec
+// registrySource fetches the plugin from the image in a remote registry.
+func registrySource(p model.Package) (fetchFunc, error) {
+	imageSpec := imageURL(p.Image)
+	if imageSpec != "https://"+p.Image {
+		log.WithFields(log.Fields{
+			"package": p.Name,
+			"image":   p.Image,
+			"url":     imageSpec,
+		}).Debug("Rewrote image reference")
 	}
 	registryURL, repository, err := splitDockerImageSpec(imageSpec)
 	if err != nil {
-		fmt.Printf("Error in splitDockerImageSpec: %v\n", err)
+		return nil, fmt.Errorf("invalid image %s for package %s: %w", p.Image, p.Name, err)
 	}
 
 	repository      = stripAfterColon(repository)
     authURL         := registryURL + "/token"
-	copyFolder      := "/compliance-framework" // Folder to take from the image
 
-	err = getDockerImageFolder(registryURL, repository, pluginTag, authURL, copyFolder, pluginPath)
-	if err != nil {
-		fmt.Printf("Error extracting folder: %v\n", err)
-	}
-
-	err = os.Chmod(pluginPath + "/" + pluginExecutableName, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to make file executable: %w", err)
-	}
-	return err
+	return func(destination string, workDir string) (string, error) {
+		return getDockerImageFolder(p, registryURL, repository, authURL, copyFolder, destination)
+	}, nil
 }
 
 func stripAfterColon(input string) string {
@@ -148,48 +121,52 @@ func splitDockerImageSpec(imageSpec string) (registryUrl string, repository stri
 	return registryUrl, repository, nil
 }
 
-func getDockerImageFolder(registryURL string, repository string, tag string, authURL string, copyFolder string, destination string) error {
+// getDockerImageFolder downloads the layers of the package image and extracts copyFolder from them into destination.
+// It returns the digest of the image manifest that was used.
+func getDockerImageFolder(p model.Package, registryURL string, repository string, authURL string, copyFolder string, destination string) (string, error) {
+	tag := p.Tag
 	ctx := context.Background()
 
 	token, err := getAuthToken(ctx, repository, authURL)
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("failed to get auth token for %s: %w", repository, err)
 	}
-	fmt.Println("Got auth token:", token)
 
-	manifest, err := getImageManifest(ctx, token, registryURL, repository, tag)
-	if err != nil {
-		panic(err)
+	reference := tag
+	if p.Digest != "" {
+		reference = p.Digest
 	}
-	fmt.Printf("Got image manifest: %+v\n", manifest)
-
-	layers, err := downloadLayers(ctx, token, manifest, registryURL, repository)
+	manifest, err := getImageManifest(ctx, token, registryURL, repository, reference)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	fmt.Println("Downloaded layers:", layers)
 
-	err = extractFolderFromLayers(layers, copyFolder, destination)
+	layers, err := downloadLayers(ctx, token, manifest, registryURL, repository, p)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
+	defer func() {
+		if err := cleanupLayers(layers); err != nil {
+			log.WithField("error", err).Warn("Failed to clean up layers")
+		}
+	}()
 
-	err = cleanupLayers(layers)
+	err = extractFolderFromLayers(layers, copyFolder, destination)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
-	fmt.Println("Folder successfully extracted and cleanup complete")
-	return nil
+	log.WithFields(log.Fields{
+		"repository": repository,
+		"tag":        tag,
+	}).Debug("Extracted plugin folder from image")
+	return manifest.Digest, nil
 }
 
 func getAuthToken(ctx context.Context, repository string, authURL string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s?service=registry.docker.io&scope=repository:%s:pull", authURL, repository), nil)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(ctx, "get auth token", func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s?service=registry.docker.io&scope=repository:%s:pull", authURL, repository), nil)
+	})
 	if err != nil {
 		return "", err
 	}
@@ -211,15 +188,23 @@ func getAuthToken(ctx context.Context, repository string, authURL string) (strin
 	return result.Token, nil
 }
 
-func getImageManifest(ctx context.Context, token string, registryURL string, repository string, tag string) (*schema2Manifest, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, repository, tag), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.oci.image.index.v1+json,application/vnd.docker.distribution.manifest.v2+json,application/vnd.oci.image.manifest.v1+json")
-
-	resp, err := http.DefaultClient.Do(req)
+// getImageManifest returns the manifest for the platform of the image with the reference, which is either a tag or a digest.
+// The digest of the returned manifest is the one the reference resolved to, which is an index for multi-platform images.
+func getImageManifest(ctx context.Context, token string, registryURL string, repository string, reference string) (*schema2Manifest, error) {
+	resp, err := doRequest(ctx, "get image manifest", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, repository, reference), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", strings.Join([]string{
+			mediaTypeOCIIndex,
+			mediaTypeDockerManifestList,
+			mediaTypeOCIManifest,
+			mediaTypeDockerManifest,
+		}, ","))
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -230,32 +215,77 @@ func getImageManifest(ctx context.Context, token string, registryURL string, rep
 		return nil, fmt.Errorf("failed to get image manifest, status: %s, body: %s", resp.Status, string(body))
 	}
 
-	var index ociIndex
-	err = json.NewDecoder(resp.Body).Decode(&index)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	// Handle OCI index to get the actual manifest
-	for _, manifestDesc := range index.Manifests {
-		if manifestDesc.MediaType == "application/vnd.docker.distribution.manifest.v2+json" ||
-			manifestDesc.MediaType == "application/vnd.oci.image.manifest.v1+json" {
-			return getManifestByDigest(ctx, token, manifestDesc.Digest, registryURL, repository)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	if isDigest(reference) && digest != reference {
+		return nil, fmt.Errorf("manifest of %s@%s has digest %s", repository, reference, digest)
+	}
+
+	// Registries usually report the media type in the header, but fall back to the document itself.
+	var doc struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	err = json.Unmarshal(body, &doc)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := doc.MediaType
+	if contentType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]); contentType != "" && contentType != "application/json" {
+		mediaType = contentType
+	}
+
+	if isImageManifest(mediaType) || (mediaType == "" && doc.Manifests == nil) {
+		// The tag points at a single-platform image.
+		var manifest schema2Manifest
+		err = json.Unmarshal(body, &manifest)
+		if err != nil {
+			return nil, err
 		}
+		manifest.Digest = digest
+		return &manifest, nil
 	}
 
-	return nil, fmt.Errorf("no valid manifest found in index")
-}
+	var index ociIndex
+	err = json.Unmarshal(body, &index)
+	if err != nil {
+		return nil, err
+	}
 
-func getManifestByDigest(ctx context.Context, token, digest string, registryURL string, repository string) (*schema2Manifest, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, repository, digest), nil)
+	desc, err := selectManifest(&index, targetPlatform())
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %w", repository, reference, err)
+	}
+
+	log.WithFields(log.Fields{
+		"repository": repository,
+		"reference":  reference,
+		"platform":   desc.Platform,
+		"digest":     desc.Digest,
+	}).Debug("Selected image manifest")
+
+	manifest, err := getManifestByDigest(ctx, token, desc.Digest, registryURL, repository)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json,application/vnd.oci.image.manifest.v1+json")
+	manifest.Digest = digest
+	return manifest, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func getManifestByDigest(ctx context.Context, token, digest string, registryURL string, repository string) (*schema2Manifest, error) {
+	resp, err := doRequest(ctx, "get image manifest by digest", func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, repository, digest), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", mediaTypeOCIManifest+","+mediaTypeDockerManifest)
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -266,142 +296,112 @@ func getManifestByDigest(ctx context.Context, token, digest string, registryURL
 		return nil, fmt.Errorf("failed to get image manifest by digest, status: %s, body: %s", resp.Status, string(body))
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(body)); actual != digest {
+		return nil, fmt.Errorf("manifest %s of %s has digest %s", digest, repository, actual)
+	}
+
 	var manifest schema2Manifest
-	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	err = json.Unmarshal(body, &manifest)
 	if err != nil {
 		return nil, err
 	}
+	manifest.Digest = digest
 
 	return &manifest, nil
 }
 
-func downloadLayers(ctx context.Context, token string, manifest *schema2Manifest, registryURL string, repository string) ([]string, error) {
-	var layers []string
-
-	for _, layer := range manifest.Layers {
-		req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/v2/%s/blobs/%s", registryURL, repository, layer.Digest), nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("failed to download layer %s, status: %s, body: %s", layer.Digest, resp.Status, string(body))
-		}
+// downloadLayers downloads the layers of the manifest into the downloads directory, publishing progress for the package.
+func downloadLayers(ctx context.Context, token string, manifest *schema2Manifest, registryURL string, repository string, p model.Package) ([]layer, error) {
+	dir, err := downloadsDir()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
 
-		layerFile := fmt.Sprintf("%s.tar.gz", strings.TrimPrefix(layer.Digest, "sha256:"))
-		outFile, err := os.Create(layerFile)
-		if err != nil {
-			return nil, err
+	var layers []layer
+	for _, l := range manifest.Layers {
+		layerFile := filepath.Join(dir, fmt.Sprintf("%s.layer", strings.TrimPrefix(l.Digest, "sha256:")))
+		current := layer{
+			Digest:    l.Digest,
+			MediaType: l.MediaType,
+			Path:      layerFile,
 		}
 
-		fmt.Println("Downloading layer:", layer.Digest)
-		_, err = io.Copy(outFile, resp.Body)
+		log.WithField("digest", l.Digest).Debug("Downloading layer")
+		use := acquireLayer(l.Digest)
+		use.download.Lock()
+		err := downloadBlob(ctx, fmt.Sprintf("%s/v2/%s/blobs/%s", registryURL, repository, l.Digest), token, l, layerFile, DownloadProgress{
+			Package: p.Name,
+			Tag:     p.Tag,
+		})
+		use.download.Unlock()
 		if err != nil {
-			outFile.Close()
+			// Downloaded and partially downloaded layers are kept to be resumed by the next attempt.
+			for _, downloaded := range append(layers, current) {
+				_ = releaseLayer(downloaded, true)
+			}
 			return nil, err
 		}
-		outFile.Close()
 
-		layers = append(layers, layerFile)
+		layers = append(layers, current)
 	}
 
 	return layers, nil
 }
 
-func extractFolderFromLayers(layers []string, copyFolder string, destination string) error {
-	for _, layerFile := range layers {
-		layer, err := os.Open(layerFile)
-		if err != nil {
-			return err
+// cleanupLayers releases the layers of an install, removing those no other install is using.
+func cleanupLayers(layers []layer) error {
+	var err error
+	for _, l := range layers {
+		if releaseErr := releaseLayer(l, false); releaseErr != nil && err == nil {
+			err = releaseErr
 		}
-		defer layer.Close()
+	}
+	return err
+}
 
-		gzipReader, err := gzip.NewReader(layer)
-		if err != nil {
-			return err
-		}
-		defer gzipReader.Close()
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
 
-		tarReader := tar.NewReader(gzipReader)
-		for {
-			header, err := tarReader.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
-			}
+func isDigest(reference string) bool {
+	return strings.HasPrefix(reference, "sha256:")
+}
 
-			if strings.HasPrefix(header.Name, strings.TrimPrefix(copyFolder, "/")) {
-				// Remove the target folder prefix from the header name
-				relativePath := strings.TrimPrefix(header.Name, strings.TrimPrefix(copyFolder, "/"))
-				targetPath := filepath.Join(destination, relativePath)
-
-				if header.Typeflag == tar.TypeDir {
-					if err := os.MkdirAll(targetPath, 0755); err != nil {
-						return err
-					}
-				} else {
-					if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-						return err
-					}
-					outFile, err := os.Create(targetPath)
-					if err != nil {
-						return err
-					}
-					if _, err := io.Copy(outFile, tarReader); err != nil {
-						outFile.Close()
-						return err
-					}
-					outFile.Close()
-				}
-				fmt.Println("Extracted:", targetPath)
-			}
-		}
-	}
-	return nil
+func isImageManifest(mediaType string) bool {
+	return mediaType == mediaTypeOCIManifest || mediaType == mediaTypeDockerManifest
 }
 
-func cleanupLayers(layers []string) error {
-	for _, layerFile := range layers {
-		err := os.Remove(layerFile)
-		if err != nil {
-			return fmt.Errorf("failed to remove layer file %s: %v", layerFile, err)
-		}
-		fmt.Println("Removed layer file:", layerFile)
-	}
-	return nil
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int               `json:"size"`
+	Digest      string            `json:"digest"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 type ociIndex struct {
-	SchemaVersion int `json:"schemaVersion"`
-	MediaType     string `json:"mediaType"`
-	Manifests     []struct {
-		MediaType string `json:"mediaType"`
-		Size      int    `json:"size"`
-		Digest    string `json:"digest"`
-	} `json:"manifests"`
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []descriptor `json:"manifests"`
 }
 
 type schema2Manifest struct {
-	SchemaVersion int `json:"schemaVersion"`
-	MediaType     string `json:"mediaType"`
-	Config        struct {
-		MediaType string `json:"mediaType"`
-		Size      int    `json:"size"`
-		Digest    string `json:"digest"`
-	} `json:"config"`
-	Layers []struct {
-		MediaType string `json:"mediaType"`
-		Size      int    `json:"size"`
-		Digest    string `json:"digest"`
-	} `json:"layers"`
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+
+	// Digest identifying the exact image that was pulled.
+	Digest string `json:"-"`
 }
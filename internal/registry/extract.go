@@ -0,0 +1,421 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// layer is an image layer blob that has been downloaded to disk.
+type layer struct {
+	Digest    string
+	MediaType string
+	Path      string
+}
+
+// extractFolderFromLayers applies the layers in order and copies the content of copyFolder into destination.
+// Whiteout entries from upper layers remove content of the lower ones, as described in the OCI image spec.
+// Entries that would end up outside the destination, either directly or through a symlink, are rejected.
+func extractFolderFromLayers(layers []layer, copyFolder string, destination string) error {
+	folder := cleanArchivePath(copyFolder)
+
+	err := os.MkdirAll(destination, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range layers {
+		err := extractLayer(l, folder, destination)
+		if err != nil {
+			return fmt.Errorf("failed to extract layer %s: %w", l.Digest, err)
+		}
+	}
+	return nil
+}
+
+func extractLayer(l layer, folder string, destination string) error {
+	file, err := os.Open(l.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := decompress(file, l.MediaType)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Paths written by this layer, relative to the destination. Opaque whiteouts only hide content
+	// of lower layers, so anything in this set is kept when one is applied.
+	added := make(map[string]bool)
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if escapesRoot(header.Name) {
+			return fmt.Errorf("entry %s escapes the image root", header.Name)
+		}
+
+		name := cleanArchivePath(header.Name)
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+
+		if base == whiteoutOpaque {
+			err = applyWhiteout(folder, dir, destination, added, true)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			// .wh.. and .wh... would otherwise remove the directory of the whiteout or its parent.
+			hidden := strings.TrimPrefix(base, whiteoutPrefix)
+			if hidden == "" || hidden == "." || hidden == ".." {
+				return fmt.Errorf("invalid whiteout %s", header.Name)
+			}
+			err = applyWhiteout(folder, path.Join(dir, hidden), destination, added, false)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		relativePath, ok := relativeTo(folder, name)
+		if !ok {
+			continue
+		}
+		if relativePath == "" {
+			// The folder itself, which is the destination.
+			continue
+		}
+
+		targetPath, err := securePath(destination, relativePath)
+		if err != nil {
+			return err
+		}
+
+		err = extractEntry(header, tarReader, folder, destination, targetPath)
+		if err != nil {
+			return err
+		}
+		markAdded(added, relativePath)
+
+		log.WithFields(log.Fields{
+			"layer": l.Digest,
+			"path":  targetPath,
+		}).Debug("Extracted")
+	}
+
+	return nil
+}
+
+func extractEntry(header *tar.Header, reader io.Reader, folder string, destination string, targetPath string) error {
+	mode := header.FileInfo().Mode().Perm()
+
+	// Replace whatever a lower layer put here, unless both are directories.
+	if info, err := os.Lstat(targetPath); err == nil {
+		if !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+			err = os.RemoveAll(targetPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := os.MkdirAll(filepath.Dir(targetPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		err = os.MkdirAll(targetPath, mode|0700)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(targetPath, mode|0700)
+	case tar.TypeReg, tar.TypeRegA:
+		outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(outFile, reader); err != nil {
+			outFile.Close()
+			return err
+		}
+		return outFile.Close()
+	case tar.TypeSymlink:
+		linkTarget, err := symlinkTarget(folder, destination, targetPath, header.Linkname)
+		if err != nil {
+			return fmt.Errorf("symlink %s: %w", header.Name, err)
+		}
+		return os.Symlink(linkTarget, targetPath)
+	case tar.TypeLink:
+		relativePath, ok := relativeTo(folder, cleanArchivePath(header.Linkname))
+		if !ok || relativePath == "" {
+			return fmt.Errorf("hard link %s points outside of %s", header.Name, folder)
+		}
+		linkTarget, err := securePath(destination, relativePath)
+		if err != nil {
+			return err
+		}
+		return os.Link(linkTarget, targetPath)
+	default:
+		log.WithFields(log.Fields{
+			"path": header.Name,
+			"type": string(header.Typeflag),
+		}).Warn("Skipping unsupported layer entry")
+		return nil
+	}
+}
+
+// applyWhiteout removes target, given as a path in the image, from the destination.
+// When opaque is set the target is a directory whose content from lower layers is removed.
+func applyWhiteout(folder string, target string, destination string, added map[string]bool, opaque bool) error {
+	if target == "" || target == folder || isAncestor(target, folder) {
+		// The whole folder is hidden by this layer.
+		return removeChildren(destination, "", added)
+	}
+
+	relativePath, ok := relativeTo(folder, target)
+	if !ok {
+		return nil
+	}
+
+	if opaque {
+		return removeChildren(destination, relativePath, added)
+	}
+
+	targetPath, err := securePath(destination, relativePath)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(targetPath)
+}
+
+// removeChildren removes the entries of the directory at relativePath that were not added by the current layer.
+func removeChildren(destination string, relativePath string, added map[string]bool) error {
+	dirPath, err := securePath(destination, relativePath)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dirPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		child := path.Join(relativePath, entry.Name())
+		if added[child] {
+			if entry.IsDir() {
+				err = removeChildren(destination, child, added)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symlinkTarget validates the link target of a symlink placed at targetPath and returns the target to use on disk.
+// Absolute targets refer to the image root and are rewritten relative to the link, when they point inside the folder.
+func symlinkTarget(folder string, destination string, targetPath string, linkname string) (string, error) {
+	if path.IsAbs(linkname) {
+		relativePath, ok := relativeTo(folder, cleanArchivePath(linkname))
+		if !ok {
+			return "", fmt.Errorf("target %s is outside of the plugin directory", linkname)
+		}
+		absolute := filepath.Join(destination, filepath.FromSlash(relativePath))
+		linkname, err := filepath.Rel(filepath.Dir(targetPath), absolute)
+		if err != nil {
+			return "", err
+		}
+		return linkname, checkLinkTarget(destination, filepath.Dir(targetPath), linkname)
+	}
+
+	err := checkLinkTarget(destination, filepath.Dir(targetPath), filepath.FromSlash(linkname))
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(linkname), nil
+}
+
+// checkLinkTarget resolves the relative link target from dir one element at a time against what has been
+// extracted so far. The target must stay inside destination at every step, and must not pass through another
+// symlink, as a chain of links could otherwise lead outside of it.
+func checkLinkTarget(destination string, dir string, linkname string) error {
+	current := dir
+	for _, part := range strings.Split(linkname, string(filepath.Separator)) {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+		}
+		if !withinDir(destination, current) {
+			return fmt.Errorf("target %s is outside of the plugin directory", linkname)
+		}
+		if current == destination {
+			continue
+		}
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("target %s passes through the symlink %s", linkname, current)
+		}
+	}
+	return nil
+}
+
+// securePath joins relativePath onto root, making sure the result stays inside root
+// and that none of its parent directories is a symlink.
+func securePath(root string, relativePath string) (string, error) {
+	targetPath := filepath.Join(root, filepath.FromSlash(relativePath))
+	if !withinDir(root, targetPath) {
+		return "", fmt.Errorf("path %s escapes the destination directory", relativePath)
+	}
+
+	rel, err := filepath.Rel(root, targetPath)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return targetPath, nil
+	}
+
+	current := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path %s traverses the symlink %s", relativePath, current)
+		}
+	}
+
+	return targetPath, nil
+}
+
+func withinDir(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// escapesRoot reports whether the tar entry name climbs above the root of the archive.
+func escapesRoot(name string) bool {
+	cleaned := path.Clean(strings.TrimPrefix(name, "/"))
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+// cleanArchivePath normalises a tar entry name into a slash separated path relative to the image root.
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// relativeTo returns name relative to folder, and whether name is folder or is inside it.
+func relativeTo(folder string, name string) (string, bool) {
+	if folder == "" {
+		return name, true
+	}
+	if name == folder {
+		return "", true
+	}
+	if strings.HasPrefix(name, folder+"/") {
+		return strings.TrimPrefix(name, folder+"/"), true
+	}
+	return "", false
+}
+
+// markAdded records relativePath and its parent directories as written by the current layer.
+func markAdded(added map[string]bool, relativePath string) {
+	for p := relativePath; p != "." && p != ""; p = path.Dir(p) {
+		added[p] = true
+	}
+}
+
+func isAncestor(ancestor string, name string) bool {
+	return ancestor != name && strings.HasPrefix(name, ancestor+"/")
+}
+
+// decompress wraps the layer blob in a reader matching its media type. Unknown media types are detected from the content.
+func decompress(r io.Reader, mediaType string) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip") || strings.HasSuffix(mediaType, ".tar.gzip"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(mediaType, "+zstd"):
+		return newZstdReader(r)
+	case strings.HasSuffix(mediaType, ".tar") || strings.HasSuffix(mediaType, ".v1.tar"):
+		return io.NopCloser(r), nil
+	}
+
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return newZstdReader(buffered)
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	Name     string
+	Type     byte
+	Mode     int64
+	Body     string
+	Linkname string
+}
+
+func writeLayer(t *testing.T, dir string, mediaType string, entries []tarEntry) layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		mode := e.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header := &tar.Header{
+			Name:     e.Name,
+			Typeflag: e.Type,
+			Mode:     mode,
+			Size:     int64(len(e.Body)),
+			Linkname: e.Linkname,
+		}
+		if e.Type != tar.TypeReg {
+			header.Size = 0
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		if e.Type == tar.TypeReg {
+			_, err := tw.Write([]byte(e.Body))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	var data bytes.Buffer
+	switch mediaType {
+	case "application/vnd.oci.image.layer.v1.tar+gzip":
+		gw := gzip.NewWriter(&data)
+		_, err := gw.Write(buf.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+	case "application/vnd.oci.image.layer.v1.tar+zstd":
+		zw, err := zstd.NewWriter(&data)
+		require.NoError(t, err)
+		_, err = zw.Write(buf.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+	default:
+		data = buf
+	}
+
+	f, err := os.CreateTemp(dir, "layer")
+	require.NoError(t, err)
+	_, err = f.Write(data.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	return layer{Digest: filepath.Base(f.Name()), MediaType: mediaType, Path: f.Name()}
+}
+
+func TestExtractAppliesLayersInOrder(t *testing.T) {
+	dir := t.TempDir()
+	destination := filepath.Join(dir, "plugin")
+
+	lower := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", []tarEntry{
+		{Name: "compliance-framework/", Type: tar.TypeDir, Mode: 0755},
+		{Name: "compliance-framework/plugin", Type: tar.TypeReg, Mode: 0755, Body: "v1"},
+		{Name: "compliance-framework/removed", Type: tar.TypeReg, Body: "gone"},
+		{Name: "compliance-framework/data/old", Type: tar.TypeReg, Body: "old"},
+		{Name: "etc/passwd", Type: tar.TypeReg, Body: "ignored"},
+	})
+	upper := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar+zstd", []tarEntry{
+		{Name: "compliance-framework/.wh.removed", Type: tar.TypeReg},
+		{Name: "compliance-framework/data/new", Type: tar.TypeReg, Body: "new"},
+		{Name: "compliance-framework/data/.wh..wh..opq", Type: tar.TypeReg},
+		{Name: "compliance-framework/current", Type: tar.TypeSymlink, Linkname: "/compliance-framework/plugin"},
+	})
+	uncompressed := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar", []tarEntry{
+		{Name: "./compliance-framework/plugin", Type: tar.TypeReg, Mode: 0750, Body: "v2"},
+	})
+
+	err := extractFolderFromLayers([]layer{lower, upper, uncompressed}, "/compliance-framework", destination)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(destination, "plugin"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(content))
+
+	info, err := os.Stat(filepath.Join(destination, "plugin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+	assert.NoFileExists(t, filepath.Join(destination, "removed"))
+	assert.NoFileExists(t, filepath.Join(destination, "data", "old"))
+	assert.FileExists(t, filepath.Join(destination, "data", "new"))
+	assert.NoDirExists(t, filepath.Join(destination, "etc"))
+
+	link, err := os.Readlink(filepath.Join(destination, "current"))
+	require.NoError(t, err)
+	assert.Equal(t, "plugin", link)
+}
+
+func TestExtractRejectsEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name: "parent traversal",
+			entries: []tarEntry{
+				{Name: "compliance-framework/../../evil", Type: tar.TypeReg, Body: "x"},
+			},
+		},
+		{
+			name: "relative symlink",
+			entries: []tarEntry{
+				{Name: "compliance-framework/link", Type: tar.TypeSymlink, Linkname: "../../../etc"},
+			},
+		},
+		{
+			name: "absolute symlink",
+			entries: []tarEntry{
+				{Name: "compliance-framework/link", Type: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			},
+		},
+		{
+			name: "chained symlink",
+			entries: []tarEntry{
+				{Name: "compliance-framework/sub/", Type: tar.TypeDir, Mode: 0755},
+				{Name: "compliance-framework/sub/a", Type: tar.TypeSymlink, Linkname: ".."},
+				{Name: "compliance-framework/sub/l", Type: tar.TypeSymlink, Linkname: "a/../evil"},
+			},
+		},
+		{
+			name: "whiteout of the directory",
+			entries: []tarEntry{
+				{Name: "compliance-framework/data/.wh..", Type: tar.TypeReg},
+			},
+		},
+		{
+			name: "whiteout of the parent directory",
+			entries: []tarEntry{
+				{Name: "compliance-framework/data/.wh...", Type: tar.TypeReg},
+			},
+		},
+		{
+			name: "hard link",
+			entries: []tarEntry{
+				{Name: "compliance-framework/link", Type: tar.TypeLink, Linkname: "etc/passwd"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar", tt.entries)
+
+			err := extractFolderFromLayers([]layer{l}, "/compliance-framework", filepath.Join(dir, "plugin"))
+			assert.Error(t, err)
+			assert.NoFileExists(t, filepath.Join(filepath.Dir(dir), "evil"))
+		})
+	}
+}
+
+func TestExtractRejectsWritesThroughSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	require.NoError(t, os.Mkdir(outside, 0755))
+
+	destination := filepath.Join(dir, "plugin")
+	require.NoError(t, os.Mkdir(destination, 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(destination, "lib")))
+
+	l := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar", []tarEntry{
+		{Name: "compliance-framework/lib/file", Type: tar.TypeReg, Body: "x"},
+	})
+
+	err := extractFolderFromLayers([]layer{l}, "/compliance-framework", destination)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(outside, "file"))
+}
@@ -0,0 +1,318 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/event"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+// downloadsDirName holds layer blobs while they are downloaded. Partial downloads are kept
+// there between attempts, so an interrupted download resumes where it stopped.
+const downloadsDirName = ".downloads"
+
+// progressInterval is the minimum time between two progress events for the same layer.
+const progressInterval = time.Second
+
+// progressSequence numbers the progress events. pubsub delivers every event from its own goroutine,
+// so they can arrive out of order and the sequence is used to put them back in order.
+var progressSequence atomic.Uint64
+
+// DownloadProgress is published on pubsub.PluginDownloadProgress while plugin layers are downloaded.
+type DownloadProgress struct {
+	Package    string `json:"package"`
+	Tag        string `json:"tag"`
+	Layer      string `json:"layer"`
+	Downloaded int64  `json:"downloaded"`
+	Total      int64  `json:"total"`
+	Attempt    int    `json:"attempt"`
+	Done       bool   `json:"done"`
+	Error      string `json:"error,omitempty"`
+	Sequence   uint64 `json:"sequence"`
+}
+
+// retryableError marks failures that may succeed when the request is sent again.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+func isRetryable(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable)
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= http.StatusInternalServerError
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not retryable or the retries are exhausted.
+// The wait between attempts doubles every time, up to the configured maximum.
+func withRetry(ctx context.Context, description string, fn func(attempt int) error) error {
+	settings := httpSettings()
+	wait := settings.retryWait
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(attempt)
+		if err == nil || !isRetryable(err) || attempt > settings.retries {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"attempt": attempt,
+			"wait":    wait,
+			"error":   err,
+		}).Warnf("Failed to %s, retrying", description)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait *= 2
+		if wait > settings.retryMaxWait {
+			wait = settings.retryMaxWait
+		}
+	}
+}
+
+// doRequest sends the request built by newRequest, retrying network errors and server side failures.
+// The caller must close the body of the returned response.
+func doRequest(ctx context.Context, description string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var resp *http.Response
+	err := withRetry(ctx, description, func(attempt int) error {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+
+		resp, err = httpClient(req.URL.Host).Do(req)
+		if err != nil {
+			return &retryableError{err: err}
+		}
+
+		if isRetryableStatus(resp.StatusCode) {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return &retryableError{err: fmt.Errorf("failed to %s, status: %s, body: %s", description, resp.Status, string(body))}
+		}
+		return nil
+	})
+	return resp, err
+}
+
+func downloadsDir() (string, error) {
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pluginsPath, downloadsDirName), nil
+}
+
+// downloadBlob downloads the blob with the digest into path, resuming a partial download left by an earlier attempt.
+// The content is verified against the digest once complete.
+func downloadBlob(ctx context.Context, url string, token string, desc descriptor, path string, progress DownloadProgress) error {
+	algorithm, expected, ok := strings.Cut(desc.Digest, ":")
+	if !ok || algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest %q", desc.Digest)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// Already downloaded and verified by an earlier attempt.
+		return nil
+	}
+
+	partial := path + ".partial"
+	progress.Layer = desc.Digest
+	progress.Total = int64(desc.Size)
+
+	return withRetry(ctx, "download layer "+desc.Digest, func(attempt int) error {
+		progress.Attempt = attempt
+		progress.Error = ""
+
+		err := downloadBlobAttempt(ctx, url, token, partial, expected, progress)
+		if err != nil {
+			progress.Error = err.Error()
+			publishProgress(progress)
+			return err
+		}
+
+		progress.Downloaded = progress.Total
+		progress.Done = true
+		publishProgress(progress)
+		return os.Rename(partial, path)
+	})
+}
+
+func downloadBlobAttempt(ctx context.Context, url string, token string, partial string, expected string, progress DownloadProgress) error {
+	settings := httpSettings()
+	if settings.downloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, settings.downloadTimeout)
+		defer cancel()
+	}
+
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Hash what is already there, so the digest covers the whole blob once the rest is appended.
+	digest := sha256.New()
+	offset, err := io.Copy(digest, file)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := httpClient(req.URL.Host).Do(req)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		log.WithFields(log.Fields{
+			"digest": progress.Layer,
+			"offset": offset,
+		}).Info("Resuming layer download")
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file may already hold the whole blob.
+		if hex.EncodeToString(digest.Sum(nil)) == expected {
+			return nil
+		}
+		return restartDownload(file, fmt.Errorf("registry rejected the range of layer %s", progress.Layer))
+	case resp.StatusCode == http.StatusOK:
+		// The registry ignored the range, so start over.
+		err = file.Truncate(0)
+		if err != nil {
+			return err
+		}
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		digest.Reset()
+		offset = 0
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("failed to download layer %s, status: %s, body: %s", progress.Layer, resp.Status, string(body))
+		if isRetryableStatus(resp.StatusCode) {
+			return &retryableError{err: err}
+		}
+		return err
+	}
+
+	progress.Downloaded = offset
+	writer := &progressWriter{progress: progress, hash: digest}
+	_, err = io.Copy(io.MultiWriter(file, writer), resp.Body)
+	if err != nil {
+		return &retryableError{err: fmt.Errorf("failed to download layer %s: %w", progress.Layer, err)}
+	}
+
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != expected {
+		return restartDownload(file, fmt.Errorf("digest mismatch for layer %s: got sha256:%s", progress.Layer, actual))
+	}
+	return nil
+}
+
+// restartDownload discards the partial download and returns err as retryable, so the next attempt starts from scratch.
+func restartDownload(file *os.File, err error) error {
+	if truncateErr := file.Truncate(0); truncateErr != nil {
+		return truncateErr
+	}
+	return &retryableError{err: err}
+}
+
+// progressWriter hashes the downloaded content and publishes progress at most once per progressInterval.
+type progressWriter struct {
+	progress  DownloadProgress
+	hash      hash.Hash
+	published time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.hash.Write(p)
+	w.progress.Downloaded += int64(n)
+
+	if time.Since(w.published) >= progressInterval {
+		w.published = time.Now()
+		publishProgress(w.progress)
+	}
+	return n, err
+}
+
+func publishProgress(progress DownloadProgress) {
+	progress.Sequence = progressSequence.Add(1)
+	pubsub.Publish(pubsub.Event{
+		Type: pubsub.PluginDownloadProgress,
+		Data: progress,
+	})
+}
+
+// ForwardProgress publishes the download progress events on the event bus topic until ctx is done.
+// Events of a layer that arrive after a newer one are dropped, so the topic sees each layer's progress in order.
+func ForwardProgress(ctx context.Context, topic string) {
+	ch, err := pubsub.Subscribe(pubsub.PluginDownloadProgress)
+	if err != nil {
+		log.Errorf("failed to subscribe to download progress: %s", err)
+		return
+	}
+
+	// The sequence of the last event forwarded for each layer.
+	forwarded := make(map[string]uint64)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			progress := e.Data.(DownloadProgress)
+			key := progress.Package + ":" + progress.Tag + "@" + progress.Layer
+			if progress.Sequence <= forwarded[key] {
+				continue
+			}
+			forwarded[key] = progress.Sequence
+
+			err := event.Publish(progress, topic)
+			if err != nil {
+				log.WithField("topic", topic).Warnf("Failed to publish download progress: %s", err)
+			}
+		}
+	}
+}
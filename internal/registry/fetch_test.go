@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadBlobRetriesAndResumes(t *testing.T) {
+	require.NoError(t, Configure(Options{RetryWait: time.Millisecond}))
+	defer func() {
+		_ = Configure(Options{})
+	}()
+
+	blob := bytes.Repeat([]byte("layer"), 1024)
+	desc := descriptor{Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(blob)), Size: len(blob)}
+
+	var requests atomic.Int32
+	var ranges atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ranges.Store(r.Header.Get("Range"))
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer server.Close()
+
+	progress, err := pubsub.Subscribe(pubsub.PluginDownloadProgress)
+	require.NoError(t, err)
+
+	// Leave half of the blob behind, as an interrupted download would.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blob.layer")
+	require.NoError(t, os.WriteFile(path+".partial", blob[:len(blob)/2], 0644))
+
+	err = downloadBlob(context.Background(), server.URL, "token", desc, path, DownloadProgress{Package: "test", Tag: "1.0.0"})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, blob, content)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.Equal(t, fmt.Sprintf("bytes=%d-", len(blob)/2), ranges.Load())
+	assert.NoFileExists(t, path+".partial")
+
+	timeout := time.After(time.Second)
+	var sequences []uint64
+	for {
+		select {
+		case e := <-progress:
+			p := e.Data.(DownloadProgress)
+			sequences = append(sequences, p.Sequence)
+			if p.Done {
+				for _, sequence := range sequences[:len(sequences)-1] {
+					assert.Less(t, sequence, p.Sequence, "the completed event is the last of the layer")
+				}
+				assert.Equal(t, "test", p.Package)
+				assert.Equal(t, desc.Digest, p.Layer)
+				assert.Equal(t, 2, p.Attempt)
+				return
+			}
+		case <-timeout:
+			t.Fatal("no completed progress event published")
+		}
+	}
+}
+
+func TestDownloadBlobRejectsDigestMismatch(t *testing.T) {
+	require.NoError(t, Configure(Options{Retries: -1}))
+	defer func() {
+		_ = Configure(Options{})
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tampered"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "blob.layer")
+	desc := descriptor{Digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("original")))}
+
+	err := downloadBlob(context.Background(), server.URL, "token", desc, path, DownloadProgress{})
+	assert.ErrorContains(t, err, "digest mismatch")
+	assert.NoFileExists(t, path)
+}
+
+func TestConcurrentInstallsShareLayers(t *testing.T) {
+	pluginsPath, err := PluginsDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(pluginsPath)
+	}()
+
+	dir := t.TempDir()
+	blobs := make(map[string][]byte)
+	layerOf := func(entries []tarEntry) descriptor {
+		l := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", entries)
+		data, err := os.ReadFile(l.Path)
+		require.NoError(t, err)
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		blobs[digest] = data
+		return descriptor{MediaType: l.MediaType, Size: len(data), Digest: digest}
+	}
+	base := layerOf([]tarEntry{{Name: "compliance-framework/base", Type: tar.TypeReg, Body: "shared"}})
+	first := layerOf([]tarEntry{{Name: "compliance-framework/plugin", Type: tar.TypeReg, Mode: 0755, Body: "first"}})
+	second := layerOf([]tarEntry{{Name: "compliance-framework/plugin", Type: tar.TypeReg, Mode: 0755, Body: "second"}})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := blobs[filepath.Base(r.URL.Path)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Slow enough for the installs to overlap.
+		time.Sleep(20 * time.Millisecond)
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i, top := range []descriptor{first, second} {
+		wg.Add(1)
+		go func(i int, top descriptor) {
+			defer wg.Done()
+
+			p := model.Package{Name: fmt.Sprintf("shared-%d", i), Tag: "1.0.0"}
+			layers, err := downloadLayers(context.Background(), "token", &schema2Manifest{Layers: []descriptor{base, top}}, server.URL, "repo", p)
+			if !assert.NoError(t, err) {
+				return
+			}
+			destination := filepath.Join(dir, p.Name)
+			assert.NoError(t, extractFolderFromLayers(layers, "/compliance-framework", destination))
+			assert.NoError(t, cleanupLayers(layers))
+
+			content, err := os.ReadFile(filepath.Join(destination, "base"))
+			assert.NoError(t, err)
+			assert.Equal(t, "shared", string(content))
+		}(i, top)
+	}
+	wg.Wait()
+
+	downloads, err := downloadsDir()
+	require.NoError(t, err)
+	entries, err := os.ReadDir(downloads)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "layers are removed once no install uses them")
+
+	// Layers left behind by failed installs are collected once they are stale.
+	require.NoError(t, os.WriteFile(filepath.Join(downloads, "abc.layer.partial"), []byte("partial"), 0644))
+	_, err = CollectGarbage(nil, nil, time.Hour)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(downloads, "abc.layer.partial"))
+	_, err = CollectGarbage(nil, nil, 0)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(downloads, "abc.layer.partial"))
+}
@@ -0,0 +1,293 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pluginExecutableName = "plugin"
+
+	// Hidden directories inside the plugins directory. Installations are staged next to
+	// the installed plugins so the final rename stays on the same filesystem.
+	stagingDirName  = ".staging"
+	previousDirName = ".previous"
+)
+
+// PluginsDir returns the directory plugins are installed into.
+func PluginsDir() (string, error) {
+	if dir := configuredDir(); dir != "" {
+		return dir, nil
+	}
+
+	ex, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(ex), "plugins"), nil
+}
+
+// PluginPath returns the installation directory of a plugin version.
+func PluginPath(name string, tag string) (string, error) {
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pluginsPath, name, tag), nil
+}
+
+// ExecutablePath returns the path of the executable of an installed plugin version.
+func ExecutablePath(name string, tag string) (string, error) {
+	pluginPath, err := PluginPath(name, tag)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pluginPath, pluginExecutableName), nil
+}
+
+// Installed reports whether the plugin of the package is installed and ready to be executed.
+// A package pinned by digest is only installed when the installed image has that digest.
+func Installed(p model.Package) bool {
+	executable, err := ExecutablePath(p.Name, p.Tag)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(executable)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	if p.Digest == "" {
+		return true
+	}
+	digest, err := InstalledDigest(p.Name, p.Tag)
+	return err == nil && digest == p.Digest
+}
+
+// InstalledDigest returns the digest of the image an installed plugin version was installed from.
+func InstalledDigest(name string, tag string) (string, error) {
+	pluginPath, err := PluginPath(name, tag)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := readMetadata(name, tag, pluginPath)
+	if err != nil {
+		return "", err
+	}
+	return metadata.Digest, nil
+}
+
+func previousPath(name string, tag string) (string, error) {
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pluginsPath, previousDirName, name, tag), nil
+}
+
+// install fetches a package into a staging directory and, once it has been verified, moves it into place.
+// The version it replaces is kept so it can be restored with Rollback. When unverified is set, the digest
+// returned by fetch is recorded as not checked against the image.
+func install(p model.Package, fetch fetchFunc, unverified bool) error {
+	if !validPathElement(p.Name) || !validPathElement(p.Tag) {
+		return fmt.Errorf("invalid package name or tag %q:%q", p.Name, p.Tag)
+	}
+
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return err
+	}
+
+	stagingRoot := filepath.Join(pluginsPath, stagingDirName)
+	err = os.MkdirAll(stagingRoot, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	staging, err := os.MkdirTemp(stagingRoot, p.Name+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+
+	content := filepath.Join(staging, "content")
+	digest, err := fetch(content, staging)
+	if err != nil {
+		return err
+	}
+	if p.Digest != "" && digest != p.Digest {
+		return fmt.Errorf("plugin %s:%s resolved to %s but is pinned to %s", p.Name, p.Tag, digest, p.Digest)
+	}
+
+	err = verifyPlugin(content)
+	if err != nil {
+		return fmt.Errorf("plugin %s:%s failed verification: %w", p.Name, p.Tag, err)
+	}
+
+	err = writeMetadata(content, Metadata{
+		Name:        p.Name,
+		Tag:         p.Tag,
+		Image:       p.Image,
+		Digest:      digest,
+		InstalledAt: time.Now().UTC(),
+		Unverified:  unverified,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = activate(p, content)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteLockfile(); err != nil {
+		log.Warnf("Failed to update the plugin lockfile: %s", err)
+	}
+	pubsub.Publish(pubsub.Event{Type: pubsub.PluginsChanged, Data: p})
+	return nil
+}
+
+// validPathElement reports whether s can be used as a single directory name inside the plugins directory.
+func validPathElement(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.HasPrefix(s, ".") && !strings.ContainsAny(s, `/\`)
+}
+
+// verifyPlugin checks that dir contains a usable plugin executable.
+func verifyPlugin(dir string) error {
+	executable := filepath.Join(dir, pluginExecutableName)
+
+	info, err := os.Stat(executable)
+	if err != nil {
+		return fmt.Errorf("plugin executable not found: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("plugin executable %s is not a regular file", executable)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("plugin executable %s is empty", executable)
+	}
+
+	err = os.Chmod(executable, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to make file executable: %w", err)
+	}
+	return nil
+}
+
+// activate moves the staged content into the plugin directory, keeping the current version as the previous one.
+func activate(p model.Package, content string) error {
+	pluginPath, err := PluginPath(p.Name, p.Tag)
+	if err != nil {
+		return err
+	}
+	previous, err := previousPath(p.Name, p.Tag)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(pluginPath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	replaced := false
+	if _, err := os.Stat(pluginPath); err == nil {
+		err = os.MkdirAll(filepath.Dir(previous), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		err = os.RemoveAll(previous)
+		if err != nil {
+			return fmt.Errorf("failed to remove previous version: %w", err)
+		}
+		err = os.Rename(pluginPath, previous)
+		if err != nil {
+			return fmt.Errorf("failed to move current version aside: %w", err)
+		}
+		replaced = true
+	}
+
+	err = os.Rename(content, pluginPath)
+	if err != nil {
+		if replaced {
+			if rollbackErr := os.Rename(previous, pluginPath); rollbackErr != nil {
+				log.WithFields(log.Fields{
+					"package": p.Name,
+					"tag":     p.Tag,
+					"error":   rollbackErr,
+				}).Error("Failed to restore previous version")
+			}
+		}
+		return fmt.Errorf("failed to install plugin %s:%s: %w", p.Name, p.Tag, err)
+	}
+
+	return nil
+}
+
+// Rollback restores the version of a plugin that was replaced by its last installation.
+// The version being rolled back is kept as the previous one, so calling Rollback again undoes it.
+func Rollback(name string, tag string) error {
+	pluginPath, err := PluginPath(name, tag)
+	if err != nil {
+		return err
+	}
+	previous, err := previousPath(name, tag)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(previous); err != nil {
+		return fmt.Errorf("no previous version of plugin %s:%s to roll back to", name, tag)
+	}
+
+	swap := previous + ".swap"
+	err = os.RemoveAll(swap)
+	if err != nil {
+		return err
+	}
+
+	hasCurrent := false
+	if _, err := os.Stat(pluginPath); err == nil {
+		err = os.Rename(pluginPath, swap)
+		if err != nil {
+			return fmt.Errorf("failed to move current version aside: %w", err)
+		}
+		hasCurrent = true
+	}
+
+	err = os.Rename(previous, pluginPath)
+	if err != nil {
+		if hasCurrent {
+			_ = os.Rename(swap, pluginPath)
+		}
+		return fmt.Errorf("failed to restore previous version: %w", err)
+	}
+
+	if hasCurrent {
+		err = os.Rename(swap, previous)
+		if err != nil {
+			return fmt.Errorf("failed to keep replaced version: %w", err)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"package": name,
+		"tag":     tag,
+	}).Info("Rolled back package")
+
+	if err := WriteLockfile(); err != nil {
+		log.Warnf("Failed to update the plugin lockfile: %s", err)
+	}
+	pubsub.Publish(pubsub.Event{Type: pubsub.PluginsChanged, Data: model.Package{Name: name, Tag: tag}})
+	return nil
+}
@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakePlugin(content string) func(destination string, workDir string) (string, error) {
+	return func(destination string, workDir string) (string, error) {
+		err := os.MkdirAll(destination, 0755)
+		if err != nil {
+			return "", err
+		}
+		return "sha256:" + content, os.WriteFile(filepath.Join(destination, pluginExecutableName), []byte(content), 0644)
+	}
+}
+
+func readPlugin(t *testing.T, p model.Package) string {
+	t.Helper()
+
+	executable, err := ExecutablePath(p.Name, p.Tag)
+	require.NoError(t, err)
+	content, err := os.ReadFile(executable)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestInstallAndRollback(t *testing.T) {
+	p := model.Package{Name: "install-test", Tag: "1.0.0"}
+	pluginsPath, err := PluginsDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(pluginsPath)
+	}()
+
+	assert.False(t, Installed(p))
+	require.NoError(t, install(p, fakePlugin("v1"), false))
+	assert.True(t, Installed(p))
+	assert.Equal(t, "v1", readPlugin(t, p))
+
+	// Installed packages are not downloaded again.
+	require.NoError(t, EnsurePackages([]model.Package{p}))
+
+	executable, err := ExecutablePath(p.Name, p.Tag)
+	require.NoError(t, err)
+	info, err := os.Stat(executable)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	require.NoError(t, install(p, fakePlugin("v2"), false))
+	assert.Equal(t, "v2", readPlugin(t, p))
+
+	// A failed download leaves the installed version untouched.
+	err = install(p, func(destination string, workDir string) (string, error) {
+		_, _ = fakePlugin("partial")(destination, workDir)
+		return "", errors.New("connection reset")
+	}, false)
+	assert.Error(t, err)
+	assert.Equal(t, "v2", readPlugin(t, p))
+
+	// So does content without a plugin executable.
+	err = install(p, func(destination string, workDir string) (string, error) {
+		return "", os.MkdirAll(destination, 0755)
+	}, false)
+	assert.Error(t, err)
+	assert.Equal(t, "v2", readPlugin(t, p))
+
+	changed, err := pubsub.Subscribe(pubsub.PluginsChanged)
+	require.NoError(t, err)
+	require.NoError(t, Rollback(p.Name, p.Tag))
+	assert.Equal(t, "v1", readPlugin(t, p))
+	select {
+	case event := <-changed:
+		assert.Equal(t, p, event.Data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rollback was not announced")
+	}
+
+	lockfile, err := ReadLockfile()
+	require.NoError(t, err)
+	assert.Equal(t, []LockedPlugin{{Name: p.Name, Tag: p.Tag, Digest: "sha256:v1"}}, lockfile.Plugins)
+
+	require.NoError(t, Rollback(p.Name, p.Tag))
+	assert.Equal(t, "v2", readPlugin(t, p))
+
+	entries, err := os.ReadDir(filepath.Join(pluginsPath, stagingDirName))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestInstallPinnedDigest(t *testing.T) {
+	pluginsPath, err := PluginsDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(pluginsPath)
+	}()
+
+	name, digest := model.SplitDigest("ghcr.io/example/plugin:1.0.0@sha256:v1")
+	assert.Equal(t, "ghcr.io/example/plugin:1.0.0", name)
+	assert.Equal(t, "sha256:v1", digest)
+
+	p := model.Package{Name: "pinned-test", Tag: "1.0.0", Image: name, Digest: digest}
+	assert.Error(t, install(p, fakePlugin("v2"), false), "content resolving to another digest is rejected")
+	assert.False(t, Installed(p))
+
+	require.NoError(t, install(p, fakePlugin("v1"), false))
+	assert.True(t, Installed(p))
+	assert.False(t, Installed(model.Package{Name: p.Name, Tag: p.Tag, Digest: "sha256:v2"}))
+
+	lockfile, err := ReadLockfile()
+	require.NoError(t, err)
+	require.Len(t, lockfile.Plugins, 1)
+	assert.Equal(t, LockedPlugin{Name: p.Name, Tag: p.Tag, Image: p.Image, Digest: digest}, lockfile.Plugins[0])
+}
@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"context"
+	"sync"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultParallelism = 4
+
+// Install is a queued or running plugin install, which callers can wait on.
+type Install struct {
+	Package model.Package
+
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the install has finished.
+func (i *Install) Done() <-chan struct{} {
+	return i.done
+}
+
+// Err returns the error the install failed with, or nil while it has not finished or if it succeeded.
+func (i *Install) Err() error {
+	select {
+	case <-i.done:
+		return i.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the install has finished or ctx is done.
+func (i *Install) Wait(ctx context.Context) error {
+	select {
+	case <-i.done:
+		return i.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Installer installs plugins in the background. Requests for a plugin version that is already queued or being
+// installed share that install, and installs are started in the order they were requested, at most parallelism at once.
+type Installer struct {
+	install func(p model.Package) error
+
+	mu          sync.Mutex
+	parallelism int
+	running     int
+	pending     []*Install
+	inflight    map[string]*Install
+}
+
+func newInstaller(parallelism int, install func(p model.Package) error) *Installer {
+	return &Installer{
+		install:     install,
+		parallelism: parallelism,
+		inflight:    make(map[string]*Install),
+	}
+}
+
+// installer is used for all plugin installs of the runtime.
+var installer = newInstaller(defaultParallelism, downloadPackage)
+
+// InstallPackage queues the install of the package, or returns the install already queued or running for it.
+func InstallPackage(p model.Package) *Install {
+	return installer.Enqueue(p)
+}
+
+func installKey(p model.Package) string {
+	return p.Name + ":" + p.Tag + "@" + p.Digest
+}
+
+// Enqueue queues the install of the package, or returns the install already queued or running for it.
+func (in *Installer) Enqueue(p model.Package) *Install {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	key := installKey(p)
+	if install, ok := in.inflight[key]; ok {
+		log.WithFields(log.Fields{
+			"package": p.Name,
+			"tag":     p.Tag,
+		}).Debug("Package is already being installed")
+		return install
+	}
+
+	install := &Install{Package: p, done: make(chan struct{})}
+	in.inflight[key] = install
+	in.pending = append(in.pending, install)
+	in.schedule()
+	return install
+}
+
+// SetParallelism changes how many installs run at once. Installs already running are not interrupted.
+func (in *Installer) SetParallelism(parallelism int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.parallelism = parallelism
+	in.schedule()
+}
+
+// schedule starts pending installs while there is capacity. The caller must hold mu.
+func (in *Installer) schedule() {
+	for in.running < in.parallelism && len(in.pending) > 0 {
+		next := in.pending[0]
+		in.pending = in.pending[1:]
+		in.running++
+		go in.run(next)
+	}
+}
+
+func (in *Installer) run(install *Install) {
+	p := install.Package
+	log.WithFields(log.Fields{
+		"package": p.Name,
+		"tag":     p.Tag,
+		"image":   p.Image,
+	}).Info("Downloading package")
+
+	err := in.install(p)
+	if err == nil {
+		log.WithFields(log.Fields{
+			"package": p.Name,
+			"tag":     p.Tag,
+			"image":   p.Image,
+		}).Info("Downloaded package")
+	}
+
+	in.mu.Lock()
+	delete(in.inflight, installKey(p))
+	in.running--
+	in.schedule()
+	in.mu.Unlock()
+
+	install.err = err
+	close(install.done)
+}
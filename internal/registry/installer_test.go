@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallerDeduplicatesAndBoundsInstalls(t *testing.T) {
+	var mu sync.Mutex
+	started := make([]string, 0)
+	release := make(chan struct{})
+
+	in := newInstaller(1, func(p model.Package) error {
+		mu.Lock()
+		started = append(started, p.Name)
+		mu.Unlock()
+
+		<-release
+		if p.Name == "broken" {
+			return errors.New("download failed")
+		}
+		return nil
+	})
+
+	first := in.Enqueue(model.Package{Name: "first", Tag: "1.0.0"})
+	assert.Same(t, first, in.Enqueue(model.Package{Name: "first", Tag: "1.0.0"}), "the running install is shared")
+	broken := in.Enqueue(model.Package{Name: "broken", Tag: "1.0.0"})
+	assert.Same(t, broken, in.Enqueue(model.Package{Name: "broken", Tag: "1.0.0"}), "the queued install is shared")
+	assert.NotSame(t, broken, in.Enqueue(model.Package{Name: "broken", Tag: "1.0.0", Digest: "sha256:other"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, broken.Wait(ctx), context.DeadlineExceeded)
+
+	mu.Lock()
+	assert.Equal(t, []string{"first"}, started, "only one install runs at a time")
+	mu.Unlock()
+
+	close(release)
+	require.NoError(t, first.Wait(context.Background()))
+	assert.EqualError(t, broken.Wait(context.Background()), "download failed")
+	assert.EqualError(t, broken.Err(), "download failed")
+
+	// Finished installs are not shared, so a failed install can be retried.
+	retry := in.Enqueue(model.Package{Name: "broken", Tag: "1.0.0"})
+	assert.NotSame(t, broken, retry)
+	assert.Error(t, retry.Wait(context.Background()))
+}
@@ -0,0 +1,277 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metadataFileName = ".install.json"
+	lastUsedFileName = ".last-used"
+)
+
+// Metadata is recorded next to every installed plugin.
+type Metadata struct {
+	Name        string    `json:"name"`
+	Tag         string    `json:"tag"`
+	Image       string    `json:"image"`
+	Digest      string    `json:"digest"`
+	InstalledAt time.Time `json:"installedAt"`
+	// Unverified is set when the digest was taken from an offline bundle rather than computed from the image.
+	Unverified bool `json:"unverified,omitempty"`
+}
+
+// InstalledPlugin describes a plugin version found in the plugins directory.
+type InstalledPlugin struct {
+	Metadata
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed,omitempty"`
+}
+
+// lastActivity returns the last time the plugin was installed or used.
+func (p InstalledPlugin) lastActivity() time.Time {
+	if p.LastUsed.After(p.InstalledAt) {
+		return p.LastUsed
+	}
+	return p.InstalledAt
+}
+
+func writeMetadata(dir string, metadata Metadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plugin metadata: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, metadataFileName), data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write plugin metadata: %w", err)
+	}
+	return nil
+}
+
+// MarkUsed records that an installed plugin version has just been used.
+func MarkUsed(name string, tag string) error {
+	pluginPath, err := PluginPath(name, tag)
+	if err != nil {
+		return err
+	}
+
+	lastUsed := filepath.Join(pluginPath, lastUsedFileName)
+	now := time.Now()
+	err = os.Chtimes(lastUsed, now, now)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.WriteFile(lastUsed, nil, 0644)
+	}
+	return err
+}
+
+// Inventory lists the plugins installed in the plugins directory.
+func Inventory() ([]InstalledPlugin, error) {
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := os.ReadDir(pluginsPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []InstalledPlugin{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugins directory: %w", err)
+	}
+
+	plugins := make([]InstalledPlugin, 0)
+	for _, name := range names {
+		if !name.IsDir() || strings.HasPrefix(name.Name(), ".") {
+			continue
+		}
+
+		tags, err := os.ReadDir(filepath.Join(pluginsPath, name.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+		}
+
+		for _, tag := range tags {
+			if !tag.IsDir() {
+				continue
+			}
+			plugin, err := inspect(name.Name(), tag.Name(), filepath.Join(pluginsPath, name.Name(), tag.Name()))
+			if err != nil {
+				return nil, err
+			}
+			plugins = append(plugins, plugin)
+		}
+	}
+
+	return plugins, nil
+}
+
+func readMetadata(name string, tag string, dir string) (Metadata, error) {
+	metadata := Metadata{Name: name, Tag: tag}
+
+	data, err := os.ReadFile(filepath.Join(dir, metadataFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Installed before metadata was recorded, so the directory is the best estimate.
+		info, err := os.Stat(dir)
+		if err != nil {
+			return metadata, err
+		}
+		metadata.InstalledAt = info.ModTime().UTC()
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, err
+	}
+
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		return metadata, fmt.Errorf("failed to read metadata of plugin %s:%s: %w", name, tag, err)
+	}
+	return metadata, nil
+}
+
+func inspect(name string, tag string, dir string) (InstalledPlugin, error) {
+	plugin := InstalledPlugin{
+		Metadata: Metadata{Name: name, Tag: tag},
+		Path:     dir,
+	}
+
+	metadata, err := readMetadata(name, tag, dir)
+	if err != nil {
+		return plugin, err
+	}
+	plugin.Metadata = metadata
+
+	if info, err := os.Stat(filepath.Join(dir, lastUsedFileName)); err == nil {
+		plugin.LastUsed = info.ModTime().UTC()
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			plugin.Size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return plugin, fmt.Errorf("failed to compute size of plugin %s:%s: %w", name, tag, err)
+	}
+
+	return plugin, nil
+}
+
+// CollectGarbage removes installed plugins that are not referenced by any of the packages and have not been
+// installed or used within the grace period, together with their previous versions. Plugins of the running
+// packages are always kept, as an assessment may be executing them. It returns the removed plugins.
+func CollectGarbage(referenced []model.Package, running []model.Package, grace time.Duration) ([]InstalledPlugin, error) {
+	keep := make(map[string]bool)
+	for _, p := range referenced {
+		keep[p.Name+":"+p.Tag] = true
+	}
+	inUse := make(map[string]bool)
+	for _, p := range running {
+		inUse[p.Name+":"+p.Tag] = true
+	}
+
+	installed, err := Inventory()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]InstalledPlugin, 0)
+	cutoff := time.Now().Add(-grace)
+	for _, plugin := range installed {
+		if keep[plugin.Name+":"+plugin.Tag] || plugin.lastActivity().After(cutoff) {
+			continue
+		}
+		if inUse[plugin.Name+":"+plugin.Tag] {
+			log.WithFields(log.Fields{
+				"package": plugin.Name,
+				"tag":     plugin.Tag,
+			}).Debug("Keeping unused package, a running assessment uses it")
+			continue
+		}
+
+		err := os.RemoveAll(plugin.Path)
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove plugin %s:%s: %w", plugin.Name, plugin.Tag, err)
+		}
+		if previous, err := previousPath(plugin.Name, plugin.Tag); err == nil {
+			err = os.RemoveAll(previous)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"package": plugin.Name,
+					"tag":     plugin.Tag,
+				}).Warnf("Failed to remove the previous version of the package: %s", err)
+			}
+		}
+		removeIfEmpty(filepath.Dir(plugin.Path))
+
+		log.WithFields(log.Fields{
+			"package": plugin.Name,
+			"tag":     plugin.Tag,
+			"size":    plugin.Size,
+		}).Info("Removed unused package")
+		removed = append(removed, plugin)
+	}
+
+	// Layers of failed installs are kept to resume them, but not forever.
+	err = removeStaleDownloads(grace)
+	if err != nil {
+		return removed, err
+	}
+
+	if len(removed) > 0 {
+		pubsub.Publish(pubsub.Event{Type: pubsub.PluginsChanged, Data: removed})
+		err = WriteLockfile()
+		if err != nil {
+			return removed, fmt.Errorf("failed to update lockfile: %w", err)
+		}
+	}
+	return removed, nil
+}
+
+// RunGarbageCollector periodically removes plugins that are no longer referenced by the packages, until ctx is done.
+// running returns the packages of the assessments that are running, whose plugins are not removed.
+func RunGarbageCollector(ctx context.Context, packages func() []model.Package, running func() []model.Package) {
+	interval, grace := gcSettings()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := CollectGarbage(packages(), running(), grace)
+			if err != nil {
+				log.Errorf("Failed to garbage collect plugins: %s", err)
+			}
+		}
+	}
+}
+
+func removeIfEmpty(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) == 0 {
+		_ = os.Remove(dir)
+	}
+}
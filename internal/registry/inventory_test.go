@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventoryAndGarbageCollection(t *testing.T) {
+	pluginsPath, err := PluginsDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(pluginsPath)
+	}()
+
+	used := model.Package{Name: "gc-test", Tag: "2.0.0", Image: "ghcr.io/example/gc-test"}
+	unused := model.Package{Name: "gc-test", Tag: "1.0.0", Image: "ghcr.io/example/gc-test"}
+	require.NoError(t, install(used, fakePlugin("v2"), false))
+	require.NoError(t, install(unused, fakePlugin("v1"), false))
+	require.NoError(t, MarkUsed(used.Name, used.Tag))
+
+	plugins, err := Inventory()
+	require.NoError(t, err)
+	require.Len(t, plugins, 2)
+	for _, p := range plugins {
+		assert.Equal(t, "ghcr.io/example/gc-test", p.Image)
+		assert.Equal(t, "sha256:v"+p.Tag[:1], p.Digest)
+		assert.NotZero(t, p.Size)
+		assert.False(t, p.InstalledAt.IsZero())
+	}
+
+	// Within the grace period nothing is removed.
+	removed, err := CollectGarbage([]model.Package{used}, nil, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	// Nor is a plugin a running assessment uses.
+	removed, err = CollectGarbage([]model.Package{used}, []model.Package{unused}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	removed, err = CollectGarbage([]model.Package{used}, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, removed, 1)
+	assert.Equal(t, unused.Tag, removed[0].Tag)
+
+	plugins, err = Inventory()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.Equal(t, used.Tag, plugins[0].Tag)
+	assert.False(t, plugins[0].LastUsed.IsZero())
+}
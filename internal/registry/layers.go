@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// layerUse tracks the installs using a downloaded layer. Plugins built from the same base image share layers,
+// so installs running at the same time download a shared layer once and it is only removed once the last
+// of them is done with it.
+type layerUse struct {
+	// download is held while the layer is downloaded, so only one install writes to its files at a time.
+	download sync.Mutex
+	users    int
+}
+
+var (
+	layersMu  sync.Mutex
+	layerUses = make(map[string]*layerUse)
+)
+
+// acquireLayer registers an install as a user of the layer with the digest.
+func acquireLayer(digest string) *layerUse {
+	layersMu.Lock()
+	defer layersMu.Unlock()
+
+	use, ok := layerUses[digest]
+	if !ok {
+		use = &layerUse{}
+		layerUses[digest] = use
+	}
+	use.users++
+	return use
+}
+
+// releaseLayer unregisters an install as a user of the layer. The layer file is removed once the last user is
+// done with it, unless keep is set, e.g. to resume the install later.
+func releaseLayer(l layer, keep bool) error {
+	layersMu.Lock()
+	defer layersMu.Unlock()
+
+	use, ok := layerUses[l.Digest]
+	if !ok {
+		return nil
+	}
+	use.users--
+	if use.users > 0 {
+		return nil
+	}
+	delete(layerUses, l.Digest)
+
+	if keep {
+		return nil
+	}
+	err := os.Remove(l.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove layer file %s: %v", l.Path, err)
+	}
+	return nil
+}
+
+func layerInUse(digest string) bool {
+	layersMu.Lock()
+	defer layersMu.Unlock()
+
+	_, ok := layerUses[digest]
+	return ok
+}
+
+// removeStaleDownloads removes the layers and partial downloads left behind by failed installs that no install
+// has resumed within the grace period.
+func removeStaleDownloads(grace time.Duration) error {
+	dir, err := downloadsDir()
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read downloads directory: %w", err)
+	}
+
+	cutoff := time.Now().Add(-grace)
+	for _, file := range files {
+		digest, _, _ := strings.Cut(file.Name(), ".")
+		if layerInUse("sha256:" + digest) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to remove stale download %s: %w", file.Name(), err)
+		}
+		log.WithField("file", file.Name()).Info("Removed stale layer download")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const lockfileName = "plugins.lock"
+
+// lockfileMu serialises writers, as plugins are installed concurrently.
+var lockfileMu sync.Mutex
+
+// Lockfile records the digest every installed plugin version resolved to, so the exact plugin builds
+// used by a runtime can be reproduced by pinning the images to these digests.
+type Lockfile struct {
+	Plugins []LockedPlugin `yaml:"plugins" json:"plugins"`
+}
+
+type LockedPlugin struct {
+	Name   string `yaml:"name" json:"name"`
+	Tag    string `yaml:"tag" json:"tag"`
+	Image  string `yaml:"image" json:"image"`
+	Digest string `yaml:"digest" json:"digest"`
+	// Unverified is set when the digest was copied from an offline bundle without being checked.
+	Unverified bool `yaml:"unverified,omitempty" json:"unverified,omitempty"`
+}
+
+// LockfilePath returns the path of the lockfile, which is kept in the plugins directory.
+func LockfilePath() (string, error) {
+	pluginsPath, err := PluginsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pluginsPath, lockfileName), nil
+}
+
+// WriteLockfile writes the lockfile for the plugins currently installed.
+func WriteLockfile() error {
+	lockfileMu.Lock()
+	defer lockfileMu.Unlock()
+
+	lockfilePath, err := LockfilePath()
+	if err != nil {
+		return err
+	}
+
+	installed, err := Inventory()
+	if err != nil {
+		return err
+	}
+
+	lockfile := Lockfile{Plugins: make([]LockedPlugin, 0, len(installed))}
+	for _, plugin := range installed {
+		lockfile.Plugins = append(lockfile.Plugins, LockedPlugin{
+			Name:       plugin.Name,
+			Tag:        plugin.Tag,
+			Image:      plugin.Image,
+			Digest:     plugin.Digest,
+			Unverified: plugin.Unverified,
+		})
+	}
+	sort.Slice(lockfile.Plugins, func(i, j int) bool {
+		if lockfile.Plugins[i].Name != lockfile.Plugins[j].Name {
+			return lockfile.Plugins[i].Name < lockfile.Plugins[j].Name
+		}
+		return lockfile.Plugins[i].Tag < lockfile.Plugins[j].Tag
+	})
+
+	data, err := yaml.Marshal(lockfile)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lockfile: %w", err)
+	}
+
+	// Write next to the lockfile and rename, so readers never see a partial file.
+	tmpPath := lockfilePath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+	return os.Rename(tmpPath, lockfilePath)
+}
+
+// ReadLockfile reads the lockfile, which is empty when no plugin has been installed yet.
+func ReadLockfile() (*Lockfile, error) {
+	lockfilePath, err := LockfilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(lockfilePath)
+	if os.IsNotExist(err) {
+		return &Lockfile{Plugins: []LockedPlugin{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+
+	var lockfile Lockfile
+	err = yaml.Unmarshal(data, &lockfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile: %w", err)
+	}
+	return &lockfile, nil
+}
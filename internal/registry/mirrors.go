@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"strings"
+)
+
+// applyMirrors rewrites the image reference using the longest mirror prefix it starts with.
+// A prefix only matches whole path segments, so ghcr.io does not match ghcr.io.example.com.
+func applyMirrors(image string, mirrors map[string]string) string {
+	scheme := ""
+	if idx := strings.Index(image, "://"); idx != -1 {
+		scheme, image = image[:idx+3], image[idx+3:]
+	}
+
+	match := ""
+	for prefix := range mirrors {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if (image == trimmed || strings.HasPrefix(image, trimmed+"/")) && len(trimmed) > len(match) {
+			match = trimmed
+		}
+	}
+	if match == "" {
+		return scheme + image
+	}
+
+	mirror := strings.TrimSuffix(mirrors[match], "/")
+	if idx := strings.Index(mirror, "://"); idx != -1 {
+		// The mirror decides how it is reached.
+		scheme = ""
+	}
+	return scheme + mirror + strings.TrimPrefix(image, match)
+}
+
+// imageURL resolves the image reference into the URL it is pulled from, applying the mirrors
+// and defaulting to HTTPS, or to plain HTTP for insecure registries.
+func imageURL(image string) string {
+	s := httpSettings()
+	image = applyMirrors(image, s.mirrors)
+
+	if strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		return image
+	}
+
+	host, _, _ := strings.Cut(image, "/")
+	if s.insecure[strings.ToLower(host)] {
+		return "http://" + image
+	}
+	return "https://" + image
+}
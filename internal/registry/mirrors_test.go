@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyMirrors(t *testing.T) {
+	mirrors := map[string]string{
+		"ghcr.io":                      "mirror.internal/ghcr",
+		"ghcr.io/compliance-framework": "mirror.internal/cf/",
+		"docker.io":                    "http://cache.local:5000/docker",
+	}
+
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{"ghcr.io/example/plugin", "mirror.internal/ghcr/example/plugin"},
+		{"ghcr.io/compliance-framework/plugin", "mirror.internal/cf/plugin"},
+		{"https://ghcr.io/example/plugin", "https://mirror.internal/ghcr/example/plugin"},
+		{"docker.io/library/plugin", "http://cache.local:5000/docker/library/plugin"},
+		{"ghcr.io.example.com/plugin", "ghcr.io.example.com/plugin"},
+		{"quay.io/example/plugin", "quay.io/example/plugin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			assert.Equal(t, tt.expected, applyMirrors(tt.image, mirrors))
+		})
+	}
+}
+
+func TestImageURLForInsecureRegistries(t *testing.T) {
+	require.NoError(t, Configure(Options{
+		Mirrors:            map[string]string{"ghcr.io": "localhost:5000/ghcr"},
+		InsecureRegistries: []string{"localhost:5000"},
+	}))
+	defer func() {
+		_ = Configure(Options{})
+	}()
+
+	assert.Equal(t, "http://localhost:5000/ghcr/example/plugin", imageURL("ghcr.io/example/plugin"))
+	assert.Equal(t, "https://quay.io/example/plugin", imageURL("quay.io/example/plugin"))
+
+	s := httpSettings()
+	assert.Same(t, s.insecureClient, s.clientFor("localhost:5000"))
+	assert.Same(t, s.client, s.clientFor("quay.io"))
+}
+
+func TestConfigureRejectsInvalidCABundle(t *testing.T) {
+	err := Configure(Options{CAFile: "/does/not/exist.pem"})
+	assert.Error(t, err)
+}
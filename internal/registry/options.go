@@ -0,0 +1,255 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures how plugin images are resolved and downloaded.
+type Options struct {
+	// Dir is the directory plugins are installed in. It defaults to the plugins directory next to the executable.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+
+	// Platform overrides the os/architecture[/variant] used to select an image from a multi-platform index.
+	// It defaults to the platform the runtime was built for.
+	Platform string `yaml:"platform,omitempty" json:"platform,omitempty"`
+
+	// GCInterval is how often unused plugins are garbage collected. Zero uses the default of one hour.
+	GCInterval time.Duration `yaml:"gcInterval,omitempty" json:"gcInterval,omitempty"`
+
+	// GCGracePeriod is how long a plugin that is no longer referenced by any plan is kept. Zero uses the default of a week.
+	GCGracePeriod time.Duration `yaml:"gcGracePeriod,omitempty" json:"gcGracePeriod,omitempty"`
+
+	// Timeout bounds connecting to a registry and waiting for its response headers. Zero uses the default of 30 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+
+	// DownloadTimeout bounds a single attempt at downloading a layer. Zero uses the default of 30 minutes.
+	DownloadTimeout time.Duration `yaml:"downloadTimeout,omitempty" json:"downloadTimeout,omitempty"`
+
+	// Retries is how many times a failed request is retried. Zero uses the default of 5, a negative value disables retries.
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+
+	// RetryWait is the wait before the first retry, which doubles on every further retry up to RetryMaxWait.
+	// Zero uses the defaults of 1 and 30 seconds.
+	RetryWait    time.Duration `yaml:"retryWait,omitempty" json:"retryWait,omitempty"`
+	RetryMaxWait time.Duration `yaml:"retryMaxWait,omitempty" json:"retryMaxWait,omitempty"`
+
+	// Mirrors rewrites image references before they are pulled. A reference starting with a key, which is a
+	// registry host optionally followed by a path, has that prefix replaced by the value,
+	// e.g. "ghcr.io: mirror.internal/ghcr". The longest matching key wins.
+	Mirrors map[string]string `yaml:"mirrors,omitempty" json:"mirrors,omitempty"`
+
+	// Proxy is the URL of the HTTP proxy used for registry requests. It defaults to the proxy set in
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities trusted for registries, in addition to the system ones.
+	CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+
+	// InsecureRegistries lists registry hosts, e.g. localhost:5000, whose TLS certificates are not verified.
+	// Images on these registries are pulled over plain HTTP unless their reference has an https:// scheme.
+	InsecureRegistries []string `yaml:"insecureRegistries,omitempty" json:"insecureRegistries,omitempty"`
+
+	// Parallelism is how many plugins are installed at once. Zero uses the default of 4.
+	Parallelism int `yaml:"parallelism,omitempty" json:"parallelism,omitempty"`
+}
+
+const (
+	defaultGCInterval      = time.Hour
+	defaultGCGracePeriod   = 7 * 24 * time.Hour
+	defaultTimeout         = 30 * time.Second
+	defaultDownloadTimeout = 30 * time.Minute
+	defaultRetries         = 5
+	defaultRetryWait       = time.Second
+	defaultRetryMaxWait    = 30 * time.Second
+)
+
+// settings holds the HTTP behaviour derived from the options.
+type settings struct {
+	client          *http.Client
+	insecureClient  *http.Client
+	insecure        map[string]bool
+	mirrors         map[string]string
+	downloadTimeout time.Duration
+	retries         int
+	retryWait       time.Duration
+	retryMaxWait    time.Duration
+}
+
+var (
+	optionsMu     sync.RWMutex
+	pluginsDir    string
+	platform      = DefaultPlatform()
+	gcInterval    = defaultGCInterval
+	gcGracePeriod = defaultGCGracePeriod
+	current, _    = newSettings(Options{})
+)
+
+// Configure applies the options to all subsequent downloads.
+func Configure(opts Options) error {
+	p := DefaultPlatform()
+	if opts.Platform != "" {
+		var err error
+		p, err = ParsePlatform(opts.Platform)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := newSettings(opts)
+	if err != nil {
+		return err
+	}
+
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+
+	pluginsDir = opts.Dir
+	platform = p
+
+	gcInterval = defaultGCInterval
+	if opts.GCInterval > 0 {
+		gcInterval = opts.GCInterval
+	}
+	gcGracePeriod = defaultGCGracePeriod
+	if opts.GCGracePeriod > 0 {
+		gcGracePeriod = opts.GCGracePeriod
+	}
+
+	current = s
+
+	parallelism := defaultParallelism
+	if opts.Parallelism > 0 {
+		parallelism = opts.Parallelism
+	}
+	installer.SetParallelism(parallelism)
+	return nil
+}
+
+func newSettings(opts Options) (settings, error) {
+	timeout := valueOrDefault(opts.Timeout, defaultTimeout)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return settings{}, fmt.Errorf("invalid registry proxy %q: %w", opts.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if opts.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return settings{}, fmt.Errorf("failed to read registry CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return settings{}, fmt.Errorf("no certificates found in registry CA bundle %s", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	insecureTransport := transport.Clone()
+	if insecureTransport.TLSClientConfig == nil {
+		insecureTransport.TLSClientConfig = &tls.Config{}
+	}
+	insecureTransport.TLSClientConfig.InsecureSkipVerify = true
+
+	insecure := make(map[string]bool)
+	for _, host := range opts.InsecureRegistries {
+		insecure[strings.ToLower(host)] = true
+	}
+
+	retries := defaultRetries
+	if opts.Retries < 0 {
+		retries = 0
+	} else if opts.Retries > 0 {
+		retries = opts.Retries
+	}
+
+	return settings{
+		// The clients have no overall timeout as they are also used for layers, which are bounded by downloadTimeout.
+		client:          &http.Client{Transport: transport},
+		insecureClient:  &http.Client{Transport: insecureTransport},
+		insecure:        insecure,
+		mirrors:         opts.Mirrors,
+		downloadTimeout: valueOrDefault(opts.DownloadTimeout, defaultDownloadTimeout),
+		retries:         retries,
+		retryWait:       valueOrDefault(opts.RetryWait, defaultRetryWait),
+		retryMaxWait:    valueOrDefault(opts.RetryMaxWait, defaultRetryMaxWait),
+	}, nil
+}
+
+// clientFor returns the client used for requests to the registry host.
+func (s settings) clientFor(host string) *http.Client {
+	if s.insecure[strings.ToLower(host)] {
+		return s.insecureClient
+	}
+	return s.client
+}
+
+func valueOrDefault(value time.Duration, def time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return def
+}
+
+func configuredDir() string {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+
+	return pluginsDir
+}
+
+func targetPlatform() Platform {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+
+	return platform
+}
+
+// GCGracePeriod returns how long unreferenced plugins are kept before they are garbage collected.
+func GCGracePeriod() time.Duration {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+
+	return gcGracePeriod
+}
+
+func gcSettings() (time.Duration, time.Duration) {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+
+	return gcInterval, gcGracePeriod
+}
+
+func httpSettings() settings {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+
+	return current
+}
+
+func httpClient(host string) *http.Client {
+	return httpSettings().clientFor(host)
+}
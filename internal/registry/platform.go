@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Platform identifies the operating system and CPU architecture a plugin image is built for.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// DefaultPlatform returns the platform the runtime itself was built for.
+func DefaultPlatform() Platform {
+	p := Platform{
+		OS:           runtime.GOOS,
+		Architecture: runtime.GOARCH,
+	}
+
+	switch runtime.GOARCH {
+	case "arm64":
+		p.Variant = "v8"
+	case "arm":
+		p.Variant = "v7"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "GOARM" && setting.Value != "" {
+					p.Variant = "v" + strings.TrimSuffix(strings.TrimSuffix(setting.Value, ",softfloat"), ",hardfloat")
+				}
+			}
+		}
+	}
+
+	return p
+}
+
+// ParsePlatform parses a platform in the os/architecture[/variant] form, e.g. linux/arm64/v8.
+func ParsePlatform(spec string) (Platform, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(spec)), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/architecture[/variant]", spec)
+	}
+
+	p := Platform{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Matches reports whether an image built for other can run on p.
+// An empty variant on either side matches any variant, and arm64 treats v8 as its default variant.
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+
+	want, got := p.Variant, other.Variant
+	if p.Architecture == "arm64" {
+		if want == "v8" {
+			want = ""
+		}
+		if got == "v8" {
+			got = ""
+		}
+	}
+	return want == "" || got == "" || want == got
+}
+
+// selectManifest returns the descriptor in the index built for the platform.
+// Exact variant matches are preferred over descriptors without a variant.
+func selectManifest(index *ociIndex, platform Platform) (*descriptor, error) {
+	var candidate *descriptor
+	available := make([]string, 0, len(index.Manifests))
+
+	for i := range index.Manifests {
+		desc := &index.Manifests[i]
+		if !isImageManifest(desc.MediaType) {
+			continue
+		}
+		if desc.Platform == nil {
+			available = append(available, "unknown")
+			continue
+		}
+		available = append(available, desc.Platform.String())
+
+		if !platform.Matches(*desc.Platform) {
+			continue
+		}
+		if desc.Platform.Variant == platform.Variant {
+			return desc, nil
+		}
+		if candidate == nil {
+			candidate = desc
+		}
+	}
+
+	if candidate != nil {
+		return candidate, nil
+	}
+	return nil, fmt.Errorf("no manifest found for platform %s, available platforms: [%s]", platform, strings.Join(available, ", "))
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm64/v8")
+	require.NoError(t, err)
+	assert.Equal(t, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, p)
+	assert.Equal(t, "linux/arm64/v8", p.String())
+
+	_, err = ParsePlatform("linux")
+	assert.Error(t, err)
+}
+
+func TestSelectManifest(t *testing.T) {
+	index := &ociIndex{
+		Manifests: []descriptor{
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:amd64", Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:armv6", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:armv7", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:arm64", Platform: &Platform{OS: "linux", Architecture: "arm64"}},
+			{MediaType: mediaTypeOCIManifest, Digest: "sha256:attestation", Platform: &Platform{OS: "unknown", Architecture: "unknown"}},
+		},
+	}
+
+	tests := []struct {
+		platform Platform
+		digest   string
+	}{
+		{Platform{OS: "linux", Architecture: "amd64"}, "sha256:amd64"},
+		{Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, "sha256:arm64"},
+		{Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "sha256:armv7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform.String(), func(t *testing.T) {
+			desc, err := selectManifest(index, tt.platform)
+			require.NoError(t, err)
+			assert.Equal(t, tt.digest, desc.Digest)
+		})
+	}
+
+	_, err := selectManifest(index, Platform{OS: "windows", Architecture: "amd64"})
+	assert.ErrorContains(t, err, "windows/amd64")
+}
@@ -0,0 +1,252 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+)
+
+// copyFolder is the folder of a plugin image that holds the plugin.
+const copyFolder = "/compliance-framework"
+
+const (
+	fileSourcePrefix          = "file://"
+	ociLayoutSourcePrefix     = "oci:"
+	dockerArchiveSourcePrefix = "docker-archive:"
+
+	annotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// fetchFunc fetches the content of a plugin into destination, using workDir for intermediate files.
+// It returns the digest identifying what was fetched.
+type fetchFunc func(destination string, workDir string) (string, error)
+
+// sourceFor returns where the package is fetched from, based on the prefix of its image:
+//   - file:///path/to/plugin, a prebuilt plugin executable
+//   - oci:/path/to/layout, an OCI image layout directory
+//   - docker-archive:/path/to/image.tar, a tarball created by docker save
+//
+// Anything else is an image in a remote registry.
+func sourceFor(p model.Package) (fetchFunc, error) {
+	switch {
+	case strings.HasPrefix(p.Image, fileSourcePrefix):
+		u, err := url.Parse(p.Image)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image %s for package %s: %w", p.Image, p.Name, err)
+		}
+		return fileSource(filepath.FromSlash(u.Path)), nil
+	case strings.HasPrefix(p.Image, ociLayoutSourcePrefix):
+		return ociLayoutSource(strings.TrimPrefix(p.Image, ociLayoutSourcePrefix), p.Tag), nil
+	case strings.HasPrefix(p.Image, dockerArchiveSourcePrefix):
+		return dockerArchiveSource(strings.TrimPrefix(p.Image, dockerArchiveSourcePrefix), p.Tag), nil
+	default:
+		return registrySource(p)
+	}
+}
+
+// fileSource copies a prebuilt plugin executable.
+func fileSource(path string) fetchFunc {
+	return func(destination string, workDir string) (string, error) {
+		in, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open plugin %s: %w", path, err)
+		}
+		defer in.Close()
+
+		err = os.MkdirAll(destination, 0755)
+		if err != nil {
+			return "", err
+		}
+
+		out, err := os.OpenFile(filepath.Join(destination, pluginExecutableName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return "", err
+		}
+		defer out.Close()
+
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(out, hash), in)
+		if err != nil {
+			return "", fmt.Errorf("failed to copy plugin %s: %w", path, err)
+		}
+		return fmt.Sprintf("sha256:%x", hash.Sum(nil)), out.Close()
+	}
+}
+
+// ociLayoutSource extracts the plugin from an image in an OCI image layout directory.
+// The image is the one annotated with the tag, or the only image in the layout.
+func ociLayoutSource(layoutPath string, tag string) fetchFunc {
+	return func(destination string, workDir string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(layoutPath, "index.json"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read OCI layout %s: %w", layoutPath, err)
+		}
+
+		var index ociIndex
+		err = json.Unmarshal(data, &index)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse OCI layout index: %w", err)
+		}
+
+		var desc *descriptor
+		for i := range index.Manifests {
+			refName := index.Manifests[i].Annotations[annotationRefName]
+			if refName == tag || strings.HasSuffix(refName, ":"+tag) {
+				desc = &index.Manifests[i]
+				break
+			}
+		}
+		if desc == nil && len(index.Manifests) == 1 {
+			desc = &index.Manifests[0]
+		}
+		if desc == nil {
+			return "", fmt.Errorf("no image tagged %s found in OCI layout %s", tag, layoutPath)
+		}
+
+		manifest, err := readLayoutManifest(layoutPath, *desc)
+		if err != nil {
+			return "", err
+		}
+
+		layers := make([]layer, 0, len(manifest.Layers))
+		for _, l := range manifest.Layers {
+			blob, err := layoutBlobPath(layoutPath, l.Digest)
+			if err != nil {
+				return "", err
+			}
+			layers = append(layers, layer{Digest: l.Digest, MediaType: l.MediaType, Path: blob})
+		}
+
+		err = extractFolderFromLayers(layers, copyFolder, destination)
+		if err != nil {
+			return "", err
+		}
+		return manifest.Digest, nil
+	}
+}
+
+// readLayoutManifest reads the image manifest referenced by desc, resolving indexes to the manifest for the platform.
+// The digest of the returned manifest is the one of desc.
+func readLayoutManifest(layoutPath string, desc descriptor) (*schema2Manifest, error) {
+	blob, err := layoutBlobPath(layoutPath, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", desc.Digest, err)
+	}
+
+	if desc.MediaType == mediaTypeOCIIndex || desc.MediaType == mediaTypeDockerManifestList {
+		var index ociIndex
+		err = json.Unmarshal(data, &index)
+		if err != nil {
+			return nil, err
+		}
+		selected, err := selectManifest(&index, targetPlatform())
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := readLayoutManifest(layoutPath, *selected)
+		if err != nil {
+			return nil, err
+		}
+		// The image is identified by its index, as it is when pulled from a registry.
+		manifest.Digest = desc.Digest
+		return manifest, nil
+	}
+
+	var manifest schema2Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Digest = desc.Digest
+	return &manifest, nil
+}
+
+func layoutBlobPath(layoutPath string, digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(digest, `/\`) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(layoutPath, "blobs", algorithm, hex), nil
+}
+
+// dockerArchiveSource extracts the plugin from a tarball created by docker save.
+// Recent Docker versions write an OCI image layout into the tarball, older ones a manifest.json.
+func dockerArchiveSource(archivePath string, tag string) fetchFunc {
+	return func(destination string, workDir string) (string, error) {
+		unpacked := filepath.Join(workDir, "archive")
+		err := extractFolderFromLayers([]layer{{Digest: archivePath, Path: archivePath}}, "", unpacked)
+		if err != nil {
+			return "", fmt.Errorf("failed to unpack %s: %w", archivePath, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(unpacked, "index.json")); err == nil {
+			return ociLayoutSource(unpacked, tag)(destination, workDir)
+		}
+
+		data, err := os.ReadFile(filepath.Join(unpacked, "manifest.json"))
+		if err != nil {
+			return "", fmt.Errorf("%s is not a docker archive: %w", archivePath, err)
+		}
+
+		var entries []struct {
+			Config   string   `json:"Config"`
+			RepoTags []string `json:"RepoTags"`
+			Layers   []string `json:"Layers"`
+		}
+		err = json.Unmarshal(data, &entries)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse docker archive manifest: %w", err)
+		}
+
+		entry := -1
+		for i, e := range entries {
+			for _, repoTag := range e.RepoTags {
+				if strings.HasSuffix(repoTag, ":"+tag) {
+					entry = i
+				}
+			}
+		}
+		if entry == -1 && len(entries) == 1 {
+			entry = 0
+		}
+		if entry == -1 {
+			return "", fmt.Errorf("no image tagged %s found in docker archive %s", tag, archivePath)
+		}
+
+		layers := make([]layer, 0, len(entries[entry].Layers))
+		for _, l := range entries[entry].Layers {
+			layerPath, err := securePath(unpacked, l)
+			if err != nil {
+				return "", err
+			}
+			layers = append(layers, layer{Digest: l, Path: layerPath})
+		}
+
+		err = extractFolderFromLayers(layers, copyFolder, destination)
+		if err != nil {
+			return "", err
+		}
+
+		// The image id is the digest of its configuration.
+		configPath, err := securePath(unpacked, entries[entry].Config)
+		if err != nil {
+			return "", err
+		}
+		config, err := os.ReadFile(configPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read image configuration: %w", err)
+		}
+		return fmt.Sprintf("sha256:%x", sha256.Sum256(config)), nil
+	}
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeBlob stores data in the OCI layout and returns its descriptor.
+func writeBlob(t *testing.T, layoutPath string, mediaType string, data []byte) descriptor {
+	t.Helper()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	blob, err := layoutBlobPath(layoutPath, digest)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(blob), 0755))
+	require.NoError(t, os.WriteFile(blob, data, 0644))
+
+	return descriptor{MediaType: mediaType, Size: len(data), Digest: digest}
+}
+
+func TestOCILayoutSource(t *testing.T) {
+	dir := t.TempDir()
+	layoutPath := filepath.Join(dir, "layout")
+
+	l := writeLayer(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", []tarEntry{
+		{Name: "compliance-framework/plugin", Type: tar.TypeReg, Mode: 0755, Body: "binary"},
+	})
+	layerData, err := os.ReadFile(l.Path)
+	require.NoError(t, err)
+
+	manifest := schema2Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Layers:        []descriptor{writeBlob(t, layoutPath, l.MediaType, layerData)},
+	}
+	manifestData, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	manifestDesc := writeBlob(t, layoutPath, mediaTypeOCIManifest, manifestData)
+	manifestDesc.Annotations = map[string]string{annotationRefName: "1.0.0"}
+
+	indexData, err := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []descriptor{manifestDesc}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(layoutPath, "index.json"), indexData, 0644))
+
+	fetch, err := sourceFor(model.Package{Name: "oci-test", Tag: "1.0.0", Image: "oci:" + layoutPath})
+	require.NoError(t, err)
+
+	destination := filepath.Join(dir, "plugin")
+	digest, err := fetch(destination, dir)
+	require.NoError(t, err)
+	assert.Equal(t, manifestDesc.Digest, digest)
+
+	content, err := os.ReadFile(filepath.Join(destination, pluginExecutableName))
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(content))
+
+	_, err = ociLayoutSource(layoutPath, "2.0.0")(filepath.Join(dir, "other"), dir)
+	assert.NoError(t, err, "the only image in the layout is used when no tag matches")
+
+	// Multi-platform images are identified by their index, like images pulled from a registry.
+	platformDesc := manifestDesc
+	platformDesc.Annotations = nil
+	platform := targetPlatform()
+	platformDesc.Platform = &platform
+	imageIndexData, err := json.Marshal(ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: []descriptor{platformDesc}})
+	require.NoError(t, err)
+	imageIndexDesc := writeBlob(t, layoutPath, mediaTypeOCIIndex, imageIndexData)
+	imageIndexDesc.Annotations = map[string]string{annotationRefName: "3.0.0"}
+	indexData, err = json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []descriptor{manifestDesc, imageIndexDesc}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(layoutPath, "index.json"), indexData, 0644))
+
+	digest, err = ociLayoutSource(layoutPath, "3.0.0")(filepath.Join(dir, "multi-platform"), dir)
+	require.NoError(t, err)
+	assert.Equal(t, imageIndexDesc.Digest, digest)
+}
+
+func TestBundleExportAndImport(t *testing.T) {
+	pluginsPath, err := PluginsDir()
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(pluginsPath)
+	}()
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "plugin-binary")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+
+	p := model.Package{Name: "bundle-test", Tag: "1.0.0", Image: "file://" + filepath.ToSlash(binary)}
+	require.NoError(t, downloadPackage(p))
+
+	bundlePath := filepath.Join(dir, "plugins.tar.gz")
+	require.NoError(t, ExportBundle([]model.Package{p}, bundlePath))
+	require.NoError(t, os.RemoveAll(pluginsPath))
+
+	imported, err := ImportBundle(bundlePath)
+	require.NoError(t, err)
+	require.Len(t, imported, 1)
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("binary"))), imported[0].Digest)
+	assert.True(t, imported[0].Unverified, "digests from a bundle are not verified")
+
+	plugins, err := Inventory()
+	require.NoError(t, err)
+	require.Len(t, plugins, 1)
+	assert.Equal(t, p.Name, plugins[0].Name)
+	assert.Equal(t, imported[0].Digest, plugins[0].Digest)
+	assert.True(t, plugins[0].Unverified)
+
+	lockfile, err := ReadLockfile()
+	require.NoError(t, err)
+	require.Len(t, lockfile.Plugins, 1)
+	assert.True(t, lockfile.Plugins[0].Unverified)
+
+	executable, err := ExecutablePath(p.Name, p.Tag)
+	require.NoError(t, err)
+	info, err := os.Stat(executable)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
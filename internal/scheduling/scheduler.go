@@ -6,6 +6,8 @@ import (
 	"github.com/compliance-framework/assessment-runtime/internal/job"
 	"github.com/compliance-framework/assessment-runtime/internal/model"
 	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
+	"reflect"
 	"sync"
 
 	"github.com/robfig/cron/v3"
@@ -19,15 +21,27 @@ type JobFunc func()
 type Scheduler struct {
 	c         *cron.Cron
 	specs     []model.JobSpec
-	runners   sync.Map
+	runners   sync.Map // *run -> *run
 	collector *job.Collector
+	labels    func() map[string]string
 }
 
-func NewScheduler(jobSpecs []model.JobSpec) *Scheduler {
+// run is an in-flight run of a job spec.
+type run struct {
+	specId   string
+	packages []model.Package
+	runner   *job.Runner
+	cancel   context.CancelFunc
+}
+
+// NewScheduler creates a scheduler for the job specs. labels returns the labels of the runtime, which the
+// templates of the specs are rendered with on every run.
+func NewScheduler(jobSpecs []model.JobSpec, labels func() map[string]string) *Scheduler {
 	s := &Scheduler{
 		c:         cron.New(cron.WithSeconds()),
 		specs:     jobSpecs,
 		collector: job.NewCollector(),
+		labels:    labels,
 	}
 	return s
 }
@@ -46,8 +60,9 @@ func (s *Scheduler) Start(ctx context.Context) {
 	go func() {
 		for event := range ch {
 			fmt.Println("received event:", event)
-			s.cleanJobs()
-			s.specs = event.Data.([]model.JobSpec)
+			specs := event.Data.([]model.JobSpec)
+			s.cleanJobs(specs)
+			s.specs = specs
 			s.loadJobs(ctx)
 		}
 	}()
@@ -62,8 +77,42 @@ func (s *Scheduler) Stop() {
 	log.Info("Stopping scheduler")
 }
 
+// Running returns the ids of the plans that are currently running.
+func (s *Scheduler) Running() []string {
+	seen := make(map[string]bool)
+	running := make([]string, 0)
+	s.runners.Range(func(key, value interface{}) bool {
+		r := value.(*run)
+		if !seen[r.specId] {
+			seen[r.specId] = true
+			running = append(running, r.specId)
+		}
+		return true
+	})
+	return running
+}
+
+// RunningPackages returns the packages used by the runs in flight.
+func (s *Scheduler) RunningPackages() []model.Package {
+	packages := make([]model.Package, 0)
+	s.runners.Range(func(key, value interface{}) bool {
+		packages = append(packages, value.(*run).packages...)
+		return true
+	})
+	return packages
+}
+
 func (s *Scheduler) loadJobs(ctx context.Context) {
 	for _, spec := range s.specs {
+		if spec.Paused {
+			log.WithFields(log.Fields{
+				"id":                 spec.Id,
+				"assessment-plan-id": spec.PlanId,
+				"title":              spec.Title,
+			}).Info("Assessment is paused, not scheduling it")
+			continue
+		}
+
 		err := s.addJob(ctx, spec)
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -77,15 +126,34 @@ func (s *Scheduler) loadJobs(ctx context.Context) {
 	}
 }
 
-func (s *Scheduler) cleanJobs() {
+// cleanJobs removes all scheduled jobs, as they are re-added from the new specs, and cancels the in-flight runs
+// of the specs that were removed, paused or changed.
+func (s *Scheduler) cleanJobs(specs []model.JobSpec) {
+	previous := make(map[string]model.JobSpec)
+	for _, spec := range s.specs {
+		previous[spec.Id] = spec
+	}
+	unchanged := make(map[string]bool)
+	for _, spec := range specs {
+		if old, ok := previous[spec.Id]; ok && !spec.Paused && reflect.DeepEqual(old, spec) {
+			unchanged[spec.Id] = true
+		}
+	}
+
 	var wg sync.WaitGroup
 
 	s.runners.Range(func(key, value interface{}) bool {
+		r := value.(*run)
+		if unchanged[r.specId] {
+			return true
+		}
+
+		log.WithField("id", r.specId).Info("Cancelling assessment run")
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			runner := value.(*job.Runner)
-			runner.Stop()
+			r.cancel()
+			r.runner.Stop()
 		}()
 		return true
 	})
@@ -101,7 +169,29 @@ func (s *Scheduler) cleanJobs() {
 // addJob adds an assessment job to the scheduler.
 func (s *Scheduler) addJob(ctx context.Context, spec model.JobSpec) error {
 	jobFn := func() {
-		runner, err := job.NewRunner(spec)
+		// Plugins are normally installed before the job is scheduled. Should one be missing, e.g. because its
+		// download failed, try again rather than failing to start it.
+		err := registry.EnsurePackages(spec.Packages())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":                 spec.Id,
+				"assessment-plan-id": spec.PlanId,
+				"title":              spec.Title,
+			}).Warnf("Skipping assessment, plugins are not ready: %s", err)
+
+			pubsub.Publish(pubsub.Event{
+				Type: pubsub.AssessmentFailed,
+				Data: fmt.Errorf("plugins not ready: %w", err),
+			})
+			return
+		}
+
+		var labels map[string]string
+		if s.labels != nil {
+			labels = s.labels()
+		}
+
+		runner, err := job.NewRunner(spec, labels)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"id":                 spec.Id,
@@ -118,13 +208,27 @@ func (s *Scheduler) addJob(ctx context.Context, spec model.JobSpec) error {
 
 		defer runner.Stop()
 
-		s.runners.Store(spec.PlanId, runner)
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		r := &run{specId: spec.Id, packages: spec.Packages(), runner: runner, cancel: cancel}
+		s.runners.Store(r, r)
+		defer s.runners.Delete(r)
 
-		results := runner.Run(ctx)
+		results := runner.Run(runCtx)
 		log.Info(results)
 
+		// The results of a cancelled run belong to a plan that was removed or changed, so they are dropped.
+		if runCtx.Err() != nil {
+			log.WithFields(log.Fields{
+				"id":                 spec.Id,
+				"assessment-plan-id": spec.PlanId,
+				"title":              spec.Title,
+			}).Info("Assessment run was cancelled")
+			return
+		}
+
 		s.collector.Process(results)
-		s.runners.Delete(spec.PlanId)
 	}
 
 	for _, task := range spec.Tasks {
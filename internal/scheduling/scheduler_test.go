@@ -0,0 +1,47 @@
+package scheduling
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/model"
+	"github.com/compliance-framework/assessment-runtime/internal/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobWithMissingPluginsFails(t *testing.T) {
+	spec := model.JobSpec{Id: "plan-1", Tasks: []model.Task{{
+		Id:       "task-1",
+		Schedule: "0 * * * * *",
+		Activities: []model.Activity{{
+			Id:       "activity-1",
+			Provider: model.Provider{Name: "missing-test", Image: "file://" + filepath.Join(t.TempDir(), "missing"), Tag: "1.0.0"},
+		}},
+	}}}
+
+	started := false
+	s := NewScheduler([]model.JobSpec{spec}, func() map[string]string {
+		started = true
+		return nil
+	})
+
+	failed, err := pubsub.Subscribe(pubsub.AssessmentFailed)
+	require.NoError(t, err)
+
+	require.NoError(t, s.addJob(context.Background(), spec))
+	entries := s.c.Entries()
+	require.Len(t, entries, 1)
+	entries[0].Job.Run()
+
+	select {
+	case event := <-failed:
+		assert.ErrorContains(t, event.Data.(error), "plugins not ready")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the assessment did not fail")
+	}
+	assert.False(t, started, "the assessment is not run without its plugins")
+	assert.Empty(t, s.Running())
+}
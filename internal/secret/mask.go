@@ -0,0 +1,110 @@
+package secret
+
+import (
+	"errors"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Hook masks resolved secrets in log entries.
+type Hook struct{}
+
+func (Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (Hook) Fire(entry *log.Entry) error {
+	entry.Message = Mask(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = Mask(v)
+		case error:
+			if masked := Mask(v.Error()); masked != v.Error() {
+				entry.Data[key] = errors.New(masked)
+			}
+		}
+	}
+	return nil
+}
+
+// Writer returns a writer that masks resolved secrets in what is written to w, e.g. the output of plugins.
+// Secrets are only masked if they are written in a single call.
+func Writer(w io.Writer) io.Writer {
+	return &maskingWriter{w: w}
+}
+
+type maskingWriter struct {
+	w io.Writer
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	_, err := m.w.Write([]byte(Mask(string(p))))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// MaskedCopy returns a copy of the message with the resolved secrets masked, leaving the message itself intact,
+// e.g. because it is passed back to a plugin.
+func MaskedCopy[T proto.Message](m T) T {
+	masked := proto.Clone(m).(T)
+	MaskMessage(masked)
+	return masked
+}
+
+// MaskMessage masks resolved secrets in all string fields of the message and the messages it contains.
+func MaskMessage(m proto.Message) {
+	if m == nil {
+		return
+	}
+	maskMessage(m.ProtoReflect())
+}
+
+func maskMessage(m protoreflect.Message) {
+	if !m.IsValid() {
+		return
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				if masked, ok := maskValue(fd, list.Get(i)); ok {
+					list.Set(i, masked)
+				}
+			}
+		case fd.IsMap():
+			values := v.Map()
+			values.Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+				if masked, ok := maskValue(fd.MapValue(), value); ok {
+					values.Set(key, masked)
+				}
+				return true
+			})
+		default:
+			if masked, ok := maskValue(fd, v); ok {
+				m.Set(fd, masked)
+			}
+		}
+		return true
+	})
+}
+
+// maskValue masks a single value of the field, returning false if it did not have to be replaced.
+func maskValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (protoreflect.Value, bool) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		if masked := Mask(v.String()); masked != v.String() {
+			return protoreflect.ValueOfString(masked), true
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		maskMessage(v.Message())
+	}
+	return v, false
+}
@@ -0,0 +1,262 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options limits what the built-in providers can read, as any plan can refer to secrets.
+type Options struct {
+	// FileRoots are the directories ${file:...} references can read from. It defaults to /run/secrets.
+	FileRoots []string `yaml:"fileRoots,omitempty" json:"fileRoots,omitempty"`
+
+	// AllowedEnv lists the environment variables ${env:...} references can read, where a trailing * matches
+	// any suffix, e.g. AZURE_*. Any variable can be read when it is empty. Variables starting with AR_
+	// configure the runtime itself and can never be read.
+	AllowedEnv []string `yaml:"allowedEnv,omitempty" json:"allowedEnv,omitempty"`
+}
+
+// defaultFileRoot is where container orchestrators mount secrets.
+const defaultFileRoot = "/run/secrets"
+
+// runtimeEnvPrefix starts the environment variables that configure the runtime, including its own credentials.
+const runtimeEnvPrefix = "AR_"
+
+// Provider resolves the secret a reference points to, e.g. the name of an environment variable for ${env:NAME}.
+type Provider interface {
+	Resolve(ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ref string) (string, error)
+
+func (f ProviderFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// minMaskLength is the length below which resolved values are not masked, as masking them would garble
+// unrelated output without protecting anything worth keeping secret.
+const minMaskLength = 4
+
+// mask replaces secret values in masked output.
+const mask = "****"
+
+// referencePattern matches references like ${env:NAME} or ${file:/run/secrets/name}.
+var referencePattern = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]*)\}`)
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"env":  ProviderFunc(resolveEnv),
+		"file": ProviderFunc(resolveFile),
+	}
+	// resolved holds every value resolved so far, so it can be masked wherever it shows up.
+	resolved = make(map[string]bool)
+	replacer = strings.NewReplacer()
+
+	fileRoots  = []string{defaultFileRoot}
+	allowedEnv []string
+)
+
+// Configure applies the options to all subsequent references.
+func Configure(opts Options) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fileRoots = []string{defaultFileRoot}
+	if len(opts.FileRoots) > 0 {
+		fileRoots = opts.FileRoots
+	}
+	allowedEnv = opts.AllowedEnv
+}
+
+// Register makes the provider resolve the references with the scheme, e.g. "vault" for ${vault:path}.
+// It replaces any provider already registered for the scheme.
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers[scheme] = provider
+}
+
+// Check returns an error if value refers to a secret provider that is not registered.
+func Check(value string) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, match := range referencePattern.FindAllStringSubmatch(value, -1) {
+		if _, ok := providers[match[1]]; !ok {
+			return fmt.Errorf("unknown secret provider %q in %s", match[1], match[0])
+		}
+	}
+	return nil
+}
+
+// Resolve replaces the secret references in value with the secrets they point to.
+// Errors name the reference but never the secret.
+func Resolve(value string) (string, error) {
+	matches := referencePattern.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		reference := value[match[0]:match[1]]
+		scheme, ref := value[match[2]:match[3]], value[match[4]:match[5]]
+
+		mu.RLock()
+		provider, ok := providers[scheme]
+		mu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("unknown secret provider %q in %s", scheme, reference)
+		}
+
+		secret, err := provider.Resolve(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", reference, err)
+		}
+		remember(secret)
+
+		b.WriteString(value[last:match[0]])
+		b.WriteString(secret)
+		last = match[1]
+	}
+	b.WriteString(value[last:])
+	return b.String(), nil
+}
+
+// ResolveMap returns a copy of values with the secret references of every value resolved.
+func ResolveMap(values map[string]string) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		secret, err := Resolve(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		result[key] = secret
+	}
+	return result, nil
+}
+
+// Mask replaces every secret resolved so far in s.
+func Mask(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return replacer.Replace(s)
+}
+
+func remember(secret string) {
+	if len(secret) < minMaskLength {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if resolved[secret] {
+		return
+	}
+	resolved[secret] = true
+
+	// Longer secrets go first, so a secret containing another one is masked as a whole.
+	secrets := make([]string, 0, len(resolved))
+	for s := range resolved {
+		secrets = append(secrets, s)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+func resolveEnv(name string) (string, error) {
+	if !envAllowed(name) {
+		return "", fmt.Errorf("environment variable %s may not be read by plans", name)
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func envAllowed(name string) bool {
+	if strings.HasPrefix(strings.ToUpper(name), runtimeEnvPrefix) {
+		return false
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if len(allowedEnv) == 0 {
+		return true
+	}
+	for _, pattern := range allowedEnv {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(name, prefix) {
+			return true
+		}
+		if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveFile(path string) (string, error) {
+	path, err := allowedFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// Secret files usually end with a newline that is not part of the secret.
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// allowedFile returns the path with its symlinks resolved, if it is within one of the file roots.
+func allowedFile(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("secret file %s is not an absolute path", path)
+	}
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	mu.RLock()
+	roots := fileRoots
+	mu.RUnlock()
+
+	for _, root := range roots {
+		resolvedRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolvedRoot, resolvedPath)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolvedPath, nil
+		}
+	}
+	return "", fmt.Errorf("secret file %s is outside of the secret directories %s", path, strings.Join(roots, ", "))
+}
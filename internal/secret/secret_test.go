@@ -0,0 +1,108 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compliance-framework/assessment-runtime/provider"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("SECRET_TEST_SUBSCRIPTION", "subscription-id")
+	secrets := t.TempDir()
+	file := filepath.Join(secrets, "client-secret")
+	require.NoError(t, os.WriteFile(file, []byte("client-secret\n"), 0600))
+	Configure(Options{FileRoots: []string{secrets}})
+	defer Configure(Options{})
+	Register("static", ProviderFunc(func(ref string) (string, error) {
+		return "static-" + ref, nil
+	}))
+
+	resolved, err := ResolveMap(map[string]string{
+		"subscription": "${env:SECRET_TEST_SUBSCRIPTION}",
+		"secret":       "${file:" + file + "}",
+		"url":          "https://${static:host}/path",
+		"plain":        "value",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"subscription": "subscription-id",
+		"secret":       "client-secret",
+		"url":          "https://static-host/path",
+		"plain":        "value",
+	}, resolved)
+
+	_, err = Resolve("${env:SECRET_TEST_MISSING}")
+	assert.ErrorContains(t, err, "${env:SECRET_TEST_MISSING}")
+	_, err = Resolve("${vault:path}")
+	assert.ErrorContains(t, err, `unknown secret provider "vault"`)
+	assert.Error(t, Check("${vault:path}"))
+	assert.NoError(t, Check("${env:NAME} and $HOME"))
+}
+
+func TestResolveRestrictions(t *testing.T) {
+	t.Setenv("AR_CONTROL_PLANE_TOKEN", "runtime-token")
+	t.Setenv("AZURE_CLIENT_ID", "client-id")
+	t.Setenv("OTHER_SECRET", "other")
+
+	secrets := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(secrets, "allowed"), []byte("allowed"), 0600))
+	outside := filepath.Join(t.TempDir(), "outside")
+	require.NoError(t, os.WriteFile(outside, []byte("outside"), 0600))
+	require.NoError(t, os.Symlink(outside, filepath.Join(secrets, "link")))
+
+	Configure(Options{FileRoots: []string{secrets}, AllowedEnv: []string{"AZURE_*", "AR_CONTROL_PLANE_TOKEN"}})
+	defer Configure(Options{})
+
+	value, err := Resolve("${env:AZURE_CLIENT_ID}")
+	require.NoError(t, err)
+	assert.Equal(t, "client-id", value)
+	_, err = Resolve("${env:OTHER_SECRET}")
+	assert.Error(t, err, "only allowed variables can be read")
+	_, err = Resolve("${env:AR_CONTROL_PLANE_TOKEN}")
+	assert.Error(t, err, "the runtime's own configuration can never be read")
+
+	value, err = Resolve("${file:" + filepath.Join(secrets, "allowed") + "}")
+	require.NoError(t, err)
+	assert.Equal(t, "allowed", value)
+	for _, path := range []string{outside, filepath.Join(secrets, "link"), filepath.Join(secrets, "..", filepath.Base(filepath.Dir(outside)), "outside"), "allowed"} {
+		_, err = Resolve("${file:" + path + "}")
+		assert.Error(t, err, path)
+	}
+}
+
+func TestMask(t *testing.T) {
+	t.Setenv("SECRET_TEST_TOKEN", "token-value")
+	_, err := Resolve("${env:SECRET_TEST_TOKEN}")
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bearer ****", Mask("Bearer token-value"))
+
+	result := &provider.ExecuteResult{
+		Logs: []*provider.LogEntry{{Title: "login", Description: "using token-value"}},
+	}
+	masked := MaskedCopy(result)
+	assert.Equal(t, "using ****", masked.Logs[0].Description)
+	assert.Equal(t, "using token-value", result.Logs[0].Description, "the original is left intact")
+	assert.NotPanics(t, func() {
+		MaskedCopy[*provider.Subject](nil)
+	})
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.AddHook(Hook{})
+	logger.WithError(errors.New("rejected token-value")).Error("failed with token-value")
+	assert.NotContains(t, buf.String(), "token-value")
+
+	buf.Reset()
+	_, err = Writer(&buf).Write([]byte("plugin logged token-value"))
+	require.NoError(t, err)
+	assert.Equal(t, "plugin logged ****", buf.String())
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/compliance-framework/assessment-runtime/internal/config"
 	"github.com/compliance-framework/assessment-runtime/internal/event"
+	"github.com/compliance-framework/assessment-runtime/internal/heartbeat"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
 	"github.com/compliance-framework/assessment-runtime/internal/scheduling"
+	"github.com/compliance-framework/assessment-runtime/internal/secret"
+	"github.com/nats-io/nats-server/v2/server"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
@@ -14,28 +20,92 @@ import (
 	"time"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.TraceLevel)
+	// Secrets resolved for the plugins are masked in everything the runtime logs.
+	log.AddHook(secret.Hook{})
+
+	if len(os.Args) > 1 && os.Args[1] == "plugins" {
+		os.Exit(pluginsCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "plans" {
+		os.Exit(plansCommand(os.Args[2:]))
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
 	var wg sync.WaitGroup
 
-	confManager, err := config.NewConfigurationManager()
+	settings, err := config.LoadSettings(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %s", err)
+	}
+
+	dump, err := settings.Dump()
+	if err != nil {
+		log.Fatalf("Failed to dump configuration: %s", err)
+	}
+	if settings.PrintConfig {
+		fmt.Print(dump)
+		os.Exit(0)
+	}
+	log.Debugf("Effective configuration:\n%s", dump)
+
+	err = registry.Configure(settings.Config.Registry)
+	if err != nil {
+		log.Fatalf("Failed to configure plugin registry: %s", err)
+	}
+
+	secret.Configure(settings.Config.Secrets)
+
+	confManager, err := config.NewConfigurationManagerFromSettings(settings)
 	if err != nil {
 		log.Fatalf("Failed to create configuration manager: %s", err)
 	}
 
-	err = event.Connect(confManager.Config().EventBusURL)
+	eventBusURL := confManager.Config().EventBusURL
+	eventBusOptions := confManager.Config().EventBus
+	if eventBusOptions.Name == "" {
+		eventBusOptions.Name = confManager.Config().RuntimeId
+	}
+
+	var eventBusServer *server.Server
+	if serverOptions := confManager.Config().EventBusServer; serverOptions.Enabled {
+		if serverOptions.Name == "" {
+			serverOptions.Name = confManager.Config().RuntimeId
+		}
+		eventBusServer, err = event.StartServer(serverOptions)
+		if err != nil {
+			log.Fatalf("Failed to start embedded event bus: %s", err)
+		}
+		// The embedded server reaches the central cluster through its leafnode connection, if any,
+		// so the runtime connects to it without the options meant for the external event bus.
+		eventBusURL = eventBusServer.ClientURL()
+		eventBusOptions = event.Options{Name: eventBusOptions.Name}
+	}
+
+	err = event.Connect(eventBusURL, eventBusOptions)
 	if err != nil {
 		log.Fatalf("Failed to connect to event bus: %s", err)
 	}
 
 	confManager.Listen()
 
-	scheduler := scheduling.NewScheduler(confManager.JobSpecs())
+	// Install the plugins of the current plans before their jobs are scheduled
+	err = registry.EnsurePackages(confManager.Packages())
+	if err != nil {
+		log.Errorf("Failed to download some of the plugins: %s", err)
+	}
+
+	scheduler := scheduling.NewScheduler(confManager.JobSpecs(), confManager.Labels)
 
 	wg.Add(1)
 	go func() {
@@ -43,6 +113,44 @@ func main() {
 		scheduler.Start(ctx)
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		confManager.Resync(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		confManager.Watch(ctx)
+	}()
+
+	reporter := &heartbeat.Reporter{
+		RuntimeId: confManager.Config().RuntimeId,
+		Version:   version,
+		Interval:  confManager.Config().HeartbeatInterval,
+		Labels:    confManager.Labels,
+		Running:   scheduler.Running,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reporter.Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registry.RunGarbageCollector(ctx, confManager.Packages, scheduler.RunningPackages)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registry.ForwardProgress(ctx, confManager.Topic("plugins.progress"))
+	}()
+
 	<-ctx.Done()
 
 	scheduler.Stop()
@@ -60,5 +168,11 @@ func main() {
 		fmt.Println("Timed out waiting for components to shut down; exiting anyway.")
 	}
 
+	// Close the connection before the embedded server goes away, so nothing published is lost.
+	event.Close()
+	if eventBusServer != nil {
+		eventBusServer.Shutdown()
+	}
+
 	os.Exit(0)
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/compliance-framework/assessment-runtime/internal/config"
+)
+
+// plansCommand implements the `plans` subcommand, used to inspect the history of the plans and roll them back.
+func plansCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: runtime plans <history|rollback> [flags]")
+		return 2
+	}
+
+	switch args[0] {
+	case "history":
+		return plansHistory(args[1:])
+	case "rollback":
+		return plansRollback(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown plans command %q\n", args[0])
+		return 2
+	}
+}
+
+func plansHistory(args []string) int {
+	flags := flag.NewFlagSet("plans history", flag.ContinueOnError)
+	location := configLocationFlags(flags)
+	asJSON := flags.Bool("json", false, "print the history as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: runtime plans history [-json] <id>")
+		return 2
+	}
+
+	settings, err := configureRegistry(location())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	confManager, err := config.NewLocalConfigurationManager(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create configuration manager: %s\n", err)
+		return 1
+	}
+
+	revisions, err := confManager.History(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read plan history: %s\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(revisions); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode plan history: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tRECORDED")
+	for _, r := range revisions {
+		fmt.Fprintf(w, "%s\t%s\n", r.Revision, formatTime(r.Timestamp))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+func plansRollback(args []string) int {
+	flags := flag.NewFlagSet("plans rollback", flag.ContinueOnError)
+	location := configLocationFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: runtime plans rollback <id> <revision>")
+		return 2
+	}
+
+	settings, err := configureRegistry(location())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	confManager, err := config.NewLocalConfigurationManager(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create configuration manager: %s\n", err)
+		return 1
+	}
+
+	// A running runtime picks the restored plan up from the assessments directory.
+	status, err := confManager.Rollback(flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to roll back plan: %s\n", err)
+		return 1
+	}
+	fmt.Printf("rolled back %s to %s: %s\n", flags.Arg(0), flags.Arg(1), status)
+	return 0
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/compliance-framework/assessment-runtime/internal/config"
+	"github.com/compliance-framework/assessment-runtime/internal/registry"
+)
+
+// pluginsCommand implements the `plugins` subcommand, used to inspect and maintain the installed plugins.
+func pluginsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: runtime plugins <list|gc|export|import> [flags]")
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		return pluginsList(args[1:])
+	case "gc":
+		return pluginsGC(args[1:])
+	case "export":
+		return pluginsExport(args[1:])
+	case "import":
+		return pluginsImport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown plugins command %q\n", args[0])
+		return 2
+	}
+}
+
+func pluginsList(args []string) int {
+	flags := flag.NewFlagSet("plugins list", flag.ContinueOnError)
+	location := configLocationFlags(flags)
+	asJSON := flags.Bool("json", false, "print the inventory as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, err := configureRegistry(location()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	plugins, err := registry.Inventory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list plugins: %s\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plugins); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode plugins: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTAG\tDIGEST\tSIZE\tINSTALLED\tLAST USED")
+	for _, p := range plugins {
+		digest := p.Digest
+		if p.Unverified {
+			digest += " (unverified)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", p.Name, p.Tag, digest, p.Size, formatTime(p.InstalledAt), formatTime(p.LastUsed))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+func pluginsGC(args []string) int {
+	flags := flag.NewFlagSet("plugins gc", flag.ContinueOnError)
+	location := configLocationFlags(flags)
+	grace := flags.Duration("grace", -1, "keep unreferenced plugins installed or used within this period, 0 to remove them all (defaults to registry.gcGracePeriod)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	settings, err := configureRegistry(location())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	confManager, err := config.NewConfigurationManagerFromSettings(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create configuration manager: %s\n", err)
+		return 1
+	}
+
+	if *grace < 0 {
+		*grace = registry.GCGracePeriod()
+	}
+
+	removed, err := registry.CollectGarbage(confManager.Packages(), nil, *grace)
+	for _, p := range removed {
+		fmt.Printf("removed %s:%s (%d bytes)\n", p.Name, p.Tag, p.Size)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to garbage collect plugins: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func pluginsExport(args []string) int {
+	flags := flag.NewFlagSet("plugins export", flag.ContinueOnError)
+	location := configLocationFlags(flags)
+	output := flags.String("o", "plugins.tar.gz", "path of the bundle to write")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	settings, err := configureRegistry(location())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	confManager, err := config.NewConfigurationManagerFromSettings(settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create configuration manager: %s\n", err)
+		return 1
+	}
+
+	packages := confManager.Packages()
+	if err := registry.ExportBundle(packages, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to export plugins: %s\n", err)
+		return 1
+	}
+	fmt.Printf("exported %d plugins to %s\n", len(packages), *output)
+	return 0
+}
+
+func pluginsImport(args []string) int {
+	flags := flag.NewFlagSet("plugins import", flag.ContinueOnError)
+	location := configLocationFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: runtime plugins import <bundle>")
+		return 2
+	}
+
+	if _, err := configureRegistry(location()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	imported, err := registry.ImportBundle(flags.Arg(0))
+	for _, p := range imported {
+		fmt.Printf("imported %s:%s %s (unverified)\n", p.Name, p.Tag, p.Digest)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to import plugins: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// configureRegistry applies the registry configuration from the configuration file and the environment,
+// so the commands work on the same plugins as the runtime. location holds the -config and -data-dir arguments
+// given to the command.
+func configureRegistry(location []string) (config.Settings, error) {
+	settings, err := config.LoadSettings(location)
+	if err != nil {
+		return settings, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := registry.Configure(settings.Config.Registry); err != nil {
+		return settings, fmt.Errorf("failed to configure plugin registry: %w", err)
+	}
+	return settings, nil
+}
+
+// configLocationFlags registers the -config and -data-dir flags of the runtime on the flags of a command,
+// and returns the arguments to load the settings with once they are parsed.
+func configLocationFlags(flags *flag.FlagSet) func() []string {
+	configFile := flags.String("config", "", "path of the configuration file (env AR_CONFIG)")
+	dataDir := flags.String("data-dir", "", "directory holding the assessments and plugins (env AR_DATA_DIR)")
+	return func() []string {
+		args := make([]string, 0, 4)
+		if *configFile != "" {
+			args = append(args, "-config", *configFile)
+		}
+		if *dataDir != "" {
+			args = append(args, "-data-dir", *dataDir)
+		}
+		return args
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}